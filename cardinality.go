@@ -0,0 +1,291 @@
+package cwpagedmetricput
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// OtherDimensionValue replaces the value of a dimension the CardinalityGuard rewrites
+const OtherDimensionValue = "__other__"
+
+// CardinalityAction is what a CardinalityGuard does with a datum that would create a new metric past its limit
+type CardinalityAction int
+
+const (
+	// CardinalityDrop drops the datum.  It is the default.
+	CardinalityDrop CardinalityAction = iota
+	// CardinalityRewrite replaces the value of the datum's highest cardinality dimension with OtherDimensionValue,
+	// then the next highest, until the rewritten metric is already tracked or fits under the limit.  A tenth of
+	// MaxMetrics, at least one, is kept for rewritten metrics.  Datum that still do not fit are dropped.
+	CardinalityRewrite
+)
+
+// CardinalityGuard limits the number of distinct metrics (metric name plus dimensions) published to each namespace
+// over a sliding window.  Every distinct metric is billed as a custom metric, so a dimension that accidentally holds
+// something like a request ID can be very expensive.  Set it on Config.CardinalityGuard.  It is safe to share a
+// CardinalityGuard between goroutines, but it should not be copied after first use.
+type CardinalityGuard struct {
+	// MaxMetrics is the number of distinct metrics allowed per namespace inside Window
+	MaxMetrics int
+	// Window is how long a metric counts against MaxMetrics after it was last seen.  Defaults to one hour.
+	Window time.Duration
+	// Action controls what happens to datum past MaxMetrics
+	Action CardinalityAction
+	// OnTrip, if set, is called with the original datum each time the guard drops or rewrites one
+	OnTrip func(namespace string, datum *cloudwatch.MetricDatum)
+
+	// now is time.Now, but can be replaced for testing
+	now        func() time.Time
+	mu         sync.Mutex
+	namespaces map[string]*namespaceCardinality
+}
+
+var _ Stage = &CardinalityGuard{}
+
+// namespaceCardinality tracks when each metric, and each value of each dimension, was last seen in a namespace
+type namespaceCardinality struct {
+	metrics   map[string]time.Time
+	dimValues map[string]map[string]time.Time
+	lastSweep time.Time
+}
+
+const defaultCardinalityWindow = time.Hour
+
+func (g *CardinalityGuard) window() time.Duration {
+	if g.Window <= 0 {
+		return defaultCardinalityWindow
+	}
+	return g.Window
+}
+
+func (g *CardinalityGuard) currentTime() time.Time {
+	if g.now != nil {
+		return g.now()
+	}
+	return time.Now()
+}
+
+// Process removes or rewrites datum of in that would push its namespace past MaxMetrics
+func (g *CardinalityGuard) Process(in *cloudwatch.PutMetricDataInput) *cloudwatch.PutMetricDataInput {
+	if in == nil || g.MaxMetrics <= 0 {
+		return in
+	}
+	namespace := aws.StringValue(in.Namespace)
+	now := g.currentTime()
+	g.mu.Lock()
+	ret, tripped := g.admitAll(in, g.namespace(namespace, now), now)
+	g.mu.Unlock()
+
+	if g.OnTrip != nil {
+		for _, d := range tripped {
+			g.OnTrip(namespace, d)
+		}
+	}
+	return ret
+}
+
+// preview is Process, but against a copy of the guard's state.  Nothing is tracked and OnTrip is not called, so the
+// guard's allowance is not used up.
+func (g *CardinalityGuard) preview(in *cloudwatch.PutMetricDataInput) *cloudwatch.PutMetricDataInput {
+	if in == nil || g.MaxMetrics <= 0 {
+		return in
+	}
+	now := g.currentTime()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ns := g.namespaces[aws.StringValue(in.Namespace)].clone(now.Add(-g.window()))
+	ret, _ := g.admitAll(in, ns, now)
+	return ret
+}
+
+// admitAll admits each datum of in to ns, returning what should be sent and the original datum that were dropped or
+// rewritten.  Must be called with mu held.
+func (g *CardinalityGuard) admitAll(in *cloudwatch.PutMetricDataInput, ns *namespaceCardinality, now time.Time) (*cloudwatch.PutMetricDataInput, []*cloudwatch.MetricDatum) {
+	ret := *in
+	ret.MetricData = make([]*cloudwatch.MetricDatum, 0, len(in.MetricData))
+	var tripped []*cloudwatch.MetricDatum
+	for _, d := range in.MetricData {
+		if d == nil {
+			ret.MetricData = append(ret.MetricData, d)
+			continue
+		}
+		out := ns.admit(d, now, g.MaxMetrics, g.Action)
+		if out != d {
+			tripped = append(tripped, d)
+		}
+		if out != nil {
+			ret.MetricData = append(ret.MetricData, out)
+		}
+	}
+	return &ret, tripped
+}
+
+// namespace returns the tracked state of a namespace, forgetting anything not seen inside the window.  Must be called
+// with mu held.
+func (g *CardinalityGuard) namespace(namespace string, now time.Time) *namespaceCardinality {
+	if g.namespaces == nil {
+		g.namespaces = make(map[string]*namespaceCardinality)
+	}
+	ns, exists := g.namespaces[namespace]
+	if !exists {
+		ns = &namespaceCardinality{
+			metrics:   make(map[string]time.Time),
+			dimValues: make(map[string]map[string]time.Time),
+			lastSweep: now,
+		}
+		g.namespaces[namespace] = ns
+	}
+	// Sweeping is linear in the number of metrics, so only do it a few times per window
+	window := g.window()
+	if now.Sub(ns.lastSweep) > window/16 {
+		ns.sweep(now.Add(-window))
+		ns.lastSweep = now
+	}
+	return ns
+}
+
+// clone returns a copy of n without anything last seen before cutoff.  n may be nil.
+func (n *namespaceCardinality) clone(cutoff time.Time) *namespaceCardinality {
+	ret := &namespaceCardinality{
+		metrics:   make(map[string]time.Time),
+		dimValues: make(map[string]map[string]time.Time),
+	}
+	if n == nil {
+		return ret
+	}
+	for k, seen := range n.metrics {
+		if !seen.Before(cutoff) {
+			ret.metrics[k] = seen
+		}
+	}
+	for name, values := range n.dimValues {
+		copied := make(map[string]time.Time, len(values))
+		for v, seen := range values {
+			if !seen.Before(cutoff) {
+				copied[v] = seen
+			}
+		}
+		if len(copied) != 0 {
+			ret.dimValues[name] = copied
+		}
+	}
+	return ret
+}
+
+// sweep forgets every metric and dimension value last seen before cutoff
+func (n *namespaceCardinality) sweep(cutoff time.Time) {
+	for k, seen := range n.metrics {
+		if seen.Before(cutoff) {
+			delete(n.metrics, k)
+		}
+	}
+	for name, values := range n.dimValues {
+		for v, seen := range values {
+			if seen.Before(cutoff) {
+				delete(values, v)
+			}
+		}
+		if len(values) == 0 {
+			delete(n.dimValues, name)
+		}
+	}
+}
+
+// admit returns the datum that should be sent in place of d: d itself, a rewritten copy, or nil to drop it
+func (n *namespaceCardinality) admit(d *cloudwatch.MetricDatum, now time.Time, maxMetrics int, action CardinalityAction) *cloudwatch.MetricDatum {
+	limit := maxMetrics
+	if action == CardinalityRewrite {
+		// Leave room for the rewritten metrics
+		limit -= rewriteReserve(maxMetrics)
+	}
+	identity := metricIdentity(d)
+	if n.fits(identity, limit) {
+		n.track(identity, d, now)
+		return d
+	}
+	if action != CardinalityRewrite {
+		return nil
+	}
+	rewritten := *d
+	rewritten.Dimensions = make([]*cloudwatch.Dimension, len(d.Dimensions))
+	copy(rewritten.Dimensions, d.Dimensions)
+	for {
+		offending := n.highestCardinality(rewritten.Dimensions)
+		if offending == -1 {
+			// Everything is rewritten and it still does not fit
+			return nil
+		}
+		rewritten.Dimensions[offending] = &cloudwatch.Dimension{
+			Name:  rewritten.Dimensions[offending].Name,
+			Value: aws.String(OtherDimensionValue),
+		}
+		identity = metricIdentity(&rewritten)
+		if n.fits(identity, maxMetrics) {
+			n.track(identity, &rewritten, now)
+			return &rewritten
+		}
+	}
+}
+
+// rewriteReserve is how many of maxMetrics CardinalityRewrite keeps for rewritten metrics: a tenth, but at least one
+func rewriteReserve(maxMetrics int) int {
+	if maxMetrics < 20 {
+		return 1
+	}
+	return maxMetrics / 10
+}
+
+// fits returns true if the metric is already tracked or there is room for it under limit
+func (n *namespaceCardinality) fits(identity string, limit int) bool {
+	_, exists := n.metrics[identity]
+	return exists || len(n.metrics) < limit
+}
+
+// highestCardinality returns the index of the dimension with the most distinct values that is not already rewritten,
+// or -1 if there is none
+func (n *namespaceCardinality) highestCardinality(dims []*cloudwatch.Dimension) int {
+	ret := -1
+	for i, dim := range dims {
+		if dim == nil || aws.StringValue(dim.Value) == OtherDimensionValue {
+			continue
+		}
+		if ret == -1 || len(n.dimValues[aws.StringValue(dim.Name)]) > len(n.dimValues[aws.StringValue(dims[ret].Name)]) {
+			ret = i
+		}
+	}
+	return ret
+}
+
+// track marks the metric and its dimension values as seen
+func (n *namespaceCardinality) track(identity string, d *cloudwatch.MetricDatum, now time.Time) {
+	n.metrics[identity] = now
+	for _, dim := range d.Dimensions {
+		if dim == nil {
+			continue
+		}
+		name := aws.StringValue(dim.Name)
+		values, exists := n.dimValues[name]
+		if !exists {
+			values = make(map[string]time.Time)
+			n.dimValues[name] = values
+		}
+		values[aws.StringValue(dim.Value)] = now
+	}
+}
+
+// metricIdentity returns a key unique to the metric a datum is billed as: its name and dimensions
+func metricIdentity(d *cloudwatch.MetricDatum) string {
+	dims := make([]string, 0, len(d.Dimensions))
+	for _, dim := range d.Dimensions {
+		if dim != nil {
+			dims = append(dims, aws.StringValue(dim.Name)+"="+aws.StringValue(dim.Value))
+		}
+	}
+	sort.Strings(dims)
+	return aws.StringValue(d.MetricName) + "\x00" + strings.Join(dims, "\x00")
+}
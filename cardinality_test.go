@@ -0,0 +1,121 @@
+package cwpagedmetricput
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+func requestIDDatum(i int) *cloudwatch.MetricDatum {
+	return &cloudwatch.MetricDatum{
+		MetricName: aws.String("latency"),
+		Value:      aws.Float64(1),
+		Dimensions: []*cloudwatch.Dimension{dim("Service", "svc"), dim("RequestID", fmt.Sprintf("req%d", i))},
+	}
+}
+
+func guardInput(from int, to int) *cloudwatch.PutMetricDataInput {
+	ret := &cloudwatch.PutMetricDataInput{Namespace: aws.String("ns")}
+	for i := from; i < to; i++ {
+		ret.MetricData = append(ret.MetricData, requestIDDatum(i))
+	}
+	return ret
+}
+
+func TestCardinalityGuard_drop(t *testing.T) {
+	now := time.Now()
+	tripped := 0
+	g := CardinalityGuard{
+		MaxMetrics: 3,
+		Window:     time.Minute,
+		OnTrip: func(namespace string, _ *cloudwatch.MetricDatum) {
+			require.Equal(t, "ns", namespace)
+			tripped++
+		},
+		now: func() time.Time {
+			return now
+		},
+	}
+	out := g.Process(guardInput(0, 5))
+	require.Len(t, out.MetricData, 3)
+	require.Equal(t, 2, tripped)
+
+	// Metrics already seen are still allowed
+	out = g.Process(guardInput(0, 5))
+	require.Len(t, out.MetricData, 3)
+
+	// Once the window passes, old metrics are forgotten
+	now = now.Add(time.Minute * 2)
+	out = g.Process(guardInput(10, 12))
+	require.Len(t, out.MetricData, 2)
+
+	// Namespaces are tracked separately
+	other := guardInput(20, 23)
+	other.Namespace = aws.String("other")
+	require.Len(t, g.Process(other).MetricData, 3)
+}
+
+func TestCardinalityGuard_rewrite(t *testing.T) {
+	g := CardinalityGuard{
+		MaxMetrics: 3,
+		Action:     CardinalityRewrite,
+	}
+	in := guardInput(0, 5)
+	out := g.Process(in)
+	require.Len(t, out.MetricData, 5)
+	// One of the three metrics is kept for the rewrite
+	for _, d := range out.MetricData[2:] {
+		require.Equal(t, map[string]string{"Service": "svc", "RequestID": OtherDimensionValue}, dimMap(d.Dimensions))
+	}
+	require.Equal(t, "req4", *in.MetricData[4].Dimensions[1].Value)
+
+	// Datum without dimensions cannot be rewritten
+	out = g.Process(&cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String("ns"),
+		MetricData: []*cloudwatch.MetricDatum{{MetricName: aws.String("new"), Value: aws.Float64(1)}},
+	})
+	require.Empty(t, out.MetricData)
+}
+
+func TestCardinalityGuard_rewriteBounded(t *testing.T) {
+	g := CardinalityGuard{
+		MaxMetrics: 10,
+		Action:     CardinalityRewrite,
+	}
+	in := &cloudwatch.PutMetricDataInput{Namespace: aws.String("ns")}
+	for i := 0; i < 1000; i++ {
+		in.MetricData = append(in.MetricData, &cloudwatch.MetricDatum{
+			MetricName: aws.String(fmt.Sprintf("m%d", i%3)),
+			Value:      aws.Float64(1),
+			Dimensions: []*cloudwatch.Dimension{
+				dim("RequestID", fmt.Sprintf("req%d", i)),
+				dim("UserID", fmt.Sprintf("user%d", i)),
+			},
+		})
+	}
+	out := g.Process(in)
+	distinct := make(map[string]struct{})
+	for _, d := range out.MetricData {
+		distinct[metricIdentity(d)] = struct{}{}
+	}
+	require.True(t, len(distinct) <= 10, "%d distinct metrics", len(distinct))
+	require.True(t, len(g.namespaces["ns"].metrics) <= 10)
+	require.Equal(t, OtherDimensionValue, dimMap(out.MetricData[len(out.MetricData)-1].Dimensions)["RequestID"])
+}
+
+func TestPager_CardinalityGuard(t *testing.T) {
+	client := &memoryCloudWatchClient{}
+	p := Pager{
+		Client: client,
+		Config: Config{
+			CardinalityGuard: &CardinalityGuard{MaxMetrics: 2},
+		},
+	}
+	_, err := p.PutMetricData(guardInput(0, 10))
+	require.NoError(t, err)
+	require.Len(t, client.in[0].MetricData, 2)
+}
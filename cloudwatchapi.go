@@ -0,0 +1,45 @@
+package cwpagedmetricput
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+)
+
+// CloudWatchAPI is a full cloudwatchiface.CloudWatchAPI that sends PutMetricData through a Pager and passes every
+// other method through to the embedded CloudWatchAPI.  Use it in code that expects the whole interface instead of
+// CloudWatchClient.
+type CloudWatchAPI struct {
+	cloudwatchiface.CloudWatchAPI
+	// Pager is required.  Its Client is usually the embedded CloudWatchAPI.
+	Pager *Pager
+}
+
+var _ cloudwatchiface.CloudWatchAPI = &CloudWatchAPI{}
+
+// WrapCloudWatchAPI returns a CloudWatchAPI that pages PutMetricData calls to api with config
+func WrapCloudWatchAPI(api cloudwatchiface.CloudWatchAPI, config Config) *CloudWatchAPI {
+	return &CloudWatchAPI{
+		CloudWatchAPI: api,
+		Pager: &Pager{
+			Client: api,
+			Config: config,
+		},
+	}
+}
+
+// PutMetricData calls Pager.PutMetricData
+func (c *CloudWatchAPI) PutMetricData(input *cloudwatch.PutMetricDataInput) (*cloudwatch.PutMetricDataOutput, error) {
+	return c.Pager.PutMetricData(input)
+}
+
+// PutMetricDataWithContext calls Pager.PutMetricDataWithContext
+func (c *CloudWatchAPI) PutMetricDataWithContext(ctx aws.Context, input *cloudwatch.PutMetricDataInput, opts ...request.Option) (*cloudwatch.PutMetricDataOutput, error) {
+	return c.Pager.PutMetricDataWithContext(ctx, input, opts...)
+}
+
+// PutMetricDataRequest calls Pager.PutMetricDataRequest
+func (c *CloudWatchAPI) PutMetricDataRequest(input *cloudwatch.PutMetricDataInput) (*request.Request, *cloudwatch.PutMetricDataOutput) {
+	return c.Pager.PutMetricDataRequest(input)
+}
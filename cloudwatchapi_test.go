@@ -0,0 +1,60 @@
+package cwpagedmetricput
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCloudWatchAPI implements ListMetrics and PutMetricDataWithContext.  Every other method panics.
+type fakeCloudWatchAPI struct {
+	cloudwatchiface.CloudWatchAPI
+	memory      memoryCloudWatchClient
+	listMetrics int
+}
+
+func (f *fakeCloudWatchAPI) ListMetrics(*cloudwatch.ListMetricsInput) (*cloudwatch.ListMetricsOutput, error) {
+	f.listMetrics++
+	return &cloudwatch.ListMetricsOutput{}, nil
+}
+
+func (f *fakeCloudWatchAPI) PutMetricDataWithContext(ctx aws.Context, in *cloudwatch.PutMetricDataInput, opts ...request.Option) (*cloudwatch.PutMetricDataOutput, error) {
+	return f.memory.PutMetricDataWithContext(ctx, in, opts...)
+}
+
+func TestWrapCloudWatchAPI(t *testing.T) {
+	input := &cloudwatch.PutMetricDataInput{
+		Namespace: aws.String("ns"),
+	}
+	for i := 0; i < 30; i++ {
+		input.MetricData = append(input.MetricData, &cloudwatch.MetricDatum{
+			MetricName: aws.String("m"),
+			Value:      aws.Float64(float64(i)),
+			Unit:       aws.String("NotAUnit"),
+		})
+	}
+	fake := &fakeCloudWatchAPI{}
+	var api cloudwatchiface.CloudWatchAPI = WrapCloudWatchAPI(fake, Config{ClearInvalidUnits: true})
+
+	_, err := api.ListMetrics(&cloudwatch.ListMetricsInput{})
+	require.NoError(t, err)
+	require.Equal(t, 1, fake.listMetrics)
+
+	_, err = api.PutMetricData(input)
+	require.NoError(t, err)
+	require.Len(t, fake.memory.in, 2)
+	require.Nil(t, fake.memory.in[0].MetricData[0].Unit)
+
+	_, err = api.PutMetricDataWithContext(context.Background(), input)
+	require.NoError(t, err)
+	require.Len(t, fake.memory.in, 4)
+
+	req, _ := api.PutMetricDataRequest(input)
+	require.NoError(t, req.Send())
+	require.Len(t, fake.memory.in, 6)
+}
@@ -0,0 +1,60 @@
+// Command cwstatsd is a StatsD and DogStatsD server that publishes metrics to CloudWatch.  AWS credentials and region
+// come from the environment, like any aws-sdk-go program.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/cep21/cwpagedmetricput"
+	"github.com/cep21/cwpagedmetricput/statsd"
+)
+
+func main() {
+	addr := flag.String("addr", ":8125", "UDP address to listen on")
+	namespace := flag.String("namespace", "statsd", "CloudWatch namespace of every metric")
+	flushInterval := flag.Duration("flush", 10*time.Second, "how often metrics are published")
+	deleteGauges := flag.Bool("delete-gauges", false, "stop sending gauges that are not updated")
+	verbose := flag.Bool("v", false, "log debug events")
+	flag.Parse()
+
+	sess, err := session.NewSession()
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger := cwpagedmetricput.StdLogger{MinLevel: cwpagedmetricput.LevelInfo}
+	if *verbose {
+		logger.MinLevel = cwpagedmetricput.LevelDebug
+	}
+	server := &statsd.Server{
+		Client: &cwpagedmetricput.Pager{
+			Client: cloudwatch.New(sess),
+			Config: cwpagedmetricput.Config{
+				ClearInvalidUnits: true,
+				Logger:            logger,
+			},
+		},
+		Namespace:     *namespace,
+		FlushInterval: *flushInterval,
+		DeleteGauges:  *deleteGauges,
+		Logger:        logger,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+	if err := server.ListenAndServe(ctx, *addr); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,181 @@
+package cwpagedmetricput
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// Usage is what Pager has sent to CloudWatch during one period.  CloudWatch bills by the number of PutMetricData
+// requests and by the number of distinct custom metrics, so these numbers are the inputs to projecting spend.
+type Usage struct {
+	// PeriodStart is when the period these numbers cover began
+	PeriodStart time.Time
+	// Requests is the number of PutMetricData requests sent, not counting requests that were split because they were
+	// too large to send
+	Requests int64
+	// PayloadBytes is the total size of request bodies, after compression, that were sent
+	PayloadBytes int64
+	// DistinctMetrics is the number of distinct namespace, metric name, and dimension combinations sent
+	DistinctMetrics int
+}
+
+// Budget limits what Pager sends to CloudWatch each period.  Once any limit is exceeded, the Degrade stages run on
+// every input until the period ends.  A zero limit is unlimited.
+type Budget struct {
+	// Period is how often usage resets.  Defaults to one hour.
+	Period time.Duration
+	// MaxRequests limits Usage.Requests
+	MaxRequests int64
+	// MaxPayloadBytes limits Usage.PayloadBytes
+	MaxPayloadBytes int64
+	// MaxDistinctMetrics limits Usage.DistinctMetrics
+	MaxDistinctMetrics int
+	// Degrade are the stages that run, after every other stage, once the budget is exceeded.  LowerResolution and
+	// Sample are useful built in stages.  Use a Filter to drop low priority metrics.
+	Degrade []Stage
+	// OnExceeded, if set, is called the first time each period that the budget is exceeded
+	OnExceeded func(usage Usage)
+}
+
+// exceeded returns true if usage is past any of the budget's limits
+func (b *Budget) exceeded(usage Usage) bool {
+	return (b.MaxRequests > 0 && usage.Requests > b.MaxRequests) ||
+		(b.MaxPayloadBytes > 0 && usage.PayloadBytes > b.MaxPayloadBytes) ||
+		(b.MaxDistinctMetrics > 0 && usage.DistinctMetrics > b.MaxDistinctMetrics)
+}
+
+// LowerResolution is a Stage that sends high resolution datum as standard, one minute resolution datum
+var LowerResolution Stage = Mapper(func(datum *cloudwatch.MetricDatum) []*cloudwatch.MetricDatum {
+	if datum == nil || aws.Int64Value(datum.StorageResolution) != 1 {
+		return []*cloudwatch.MetricDatum{datum}
+	}
+	ret := *datum
+	ret.StorageResolution = aws.Int64(60)
+	return []*cloudwatch.MetricDatum{&ret}
+})
+
+// Sample returns a Stage that randomly keeps only rate (between 0 and 1) of the datum
+func Sample(rate float64) Stage {
+	return Filter(func(_ *cloudwatch.MetricDatum) bool {
+		// nolint: gosec
+		return rand.Float64() < rate
+	})
+}
+
+const defaultUsagePeriod = time.Hour
+
+// usageTracker accumulates Usage for the current period
+type usageTracker struct {
+	mu               sync.Mutex
+	usage            Usage
+	metrics          map[string]struct{}
+	notifiedExceeded bool
+
+	// now is time.Now, but can be replaced for testing
+	now func() time.Time
+}
+
+// rotate starts a new period if the current one is over.  Must be called with mu held.
+func (u *usageTracker) rotate(period time.Duration) {
+	now := time.Now()
+	if u.now != nil {
+		now = u.now()
+	}
+	if period <= 0 {
+		period = defaultUsagePeriod
+	}
+	if u.metrics != nil && now.Sub(u.usage.PeriodStart) < period {
+		return
+	}
+	u.usage = Usage{
+		PeriodStart: now.Truncate(period),
+	}
+	u.metrics = make(map[string]struct{})
+	u.notifiedExceeded = false
+}
+
+// snapshot returns the usage of the current period
+func (u *usageTracker) snapshot(period time.Duration) Usage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rotate(period)
+	return u.usage
+}
+
+// addMetrics records the distinct metrics of in
+func (u *usageTracker) addMetrics(period time.Duration, in *cloudwatch.PutMetricDataInput) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rotate(period)
+	prefix := aws.StringValue(in.Namespace) + "\x00"
+	for _, d := range in.MetricData {
+		if d != nil {
+			u.metrics[prefix+metricIdentity(d)] = struct{}{}
+		}
+	}
+	u.usage.DistinctMetrics = len(u.metrics)
+}
+
+// addRequest records a single sent request
+func (u *usageTracker) addRequest(period time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rotate(period)
+	u.usage.Requests++
+}
+
+// addPayload records the compressed size of a sent request body
+func (u *usageTracker) addPayload(period time.Duration, size int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rotate(period)
+	u.usage.PayloadBytes += size
+}
+
+// checkBudget returns the current usage and whether b is exceeded.  The last return is true only the first time b is
+// exceeded each period.
+func (u *usageTracker) checkBudget(b *Budget) (Usage, bool, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rotate(b.Period)
+	if !b.exceeded(u.usage) {
+		return u.usage, false, false
+	}
+	firstTime := !u.notifiedExceeded
+	u.notifiedExceeded = true
+	return u.usage, true, firstTime
+}
+
+// usagePeriod is how long each Usage period lasts
+func (c *Config) usagePeriod() time.Duration {
+	if c.Budget == nil || c.Budget.Period <= 0 {
+		return defaultUsagePeriod
+	}
+	return c.Budget.Period
+}
+
+// Usage returns what Pager has sent during the current period.  The period is Config.Budget.Period, or one hour
+// without a Budget.
+func (c *Pager) Usage() Usage {
+	return c.usage.snapshot(c.Config.usagePeriod())
+}
+
+// applyBudget runs the Budget's Degrade stages on in if the budget is exceeded
+func (c *Pager) applyBudget(in *cloudwatch.PutMetricDataInput) *cloudwatch.PutMetricDataInput {
+	b := c.Config.Budget
+	if b == nil {
+		return in
+	}
+	usage, isExceeded, firstTime := c.usage.checkBudget(b)
+	if !isExceeded {
+		return in
+	}
+	if firstTime && b.OnExceeded != nil {
+		b.OnExceeded(usage)
+	}
+	return c.processStages(in, b.Degrade, DropReasonBudget)
+}
@@ -0,0 +1,77 @@
+package cwpagedmetricput
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPager_Usage(t *testing.T) {
+	now := time.Now()
+	p := Pager{
+		Client: &memoryCloudWatchClient{},
+	}
+	p.usage.now = func() time.Time {
+		return now
+	}
+	_, err := p.PutMetricData(guardInput(0, maxDatumSize+1))
+	require.NoError(t, err)
+	_, err = p.PutMetricData(guardInput(0, 1))
+	require.NoError(t, err)
+	usage := p.Usage()
+	require.Equal(t, int64(3), usage.Requests)
+	require.Equal(t, maxDatumSize+1, usage.DistinctMetrics)
+	require.Equal(t, now.Truncate(time.Hour), usage.PeriodStart)
+
+	now = now.Add(time.Hour)
+	require.Equal(t, Usage{PeriodStart: now.Truncate(time.Hour)}, p.Usage())
+}
+
+func TestPager_Budget(t *testing.T) {
+	client := &memoryCloudWatchClient{}
+	exceeded := 0
+	p := Pager{
+		Client: client,
+		Config: Config{
+			Budget: &Budget{
+				MaxRequests: 1,
+				Degrade: []Stage{
+					LowerResolution,
+					Filter(func(d *cloudwatch.MetricDatum) bool {
+						return *d.MetricName != "low_priority"
+					}),
+				},
+				OnExceeded: func(usage Usage) {
+					require.Equal(t, int64(2), usage.Requests)
+					exceeded++
+				},
+			},
+		},
+	}
+	in := &cloudwatch.PutMetricDataInput{
+		Namespace: aws.String("ns"),
+		MetricData: []*cloudwatch.MetricDatum{
+			{MetricName: aws.String("important"), Value: aws.Float64(1), StorageResolution: aws.Int64(1)},
+			{MetricName: aws.String("low_priority"), Value: aws.Float64(1)},
+		},
+	}
+	for i := 0; i < 3; i++ {
+		_, err := p.PutMetricData(in)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, exceeded)
+	require.Len(t, client.in, 3)
+	require.Len(t, client.in[1].MetricData, 2)
+	require.Len(t, client.in[2].MetricData, 1)
+	require.Equal(t, int64(60), *client.in[2].MetricData[0].StorageResolution)
+	require.Equal(t, int64(1), *in.MetricData[0].StorageResolution)
+}
+
+func TestSample(t *testing.T) {
+	in := guardInput(0, 100)
+	require.Empty(t, Sample(0).Process(in).MetricData)
+	require.Len(t, Sample(1).Process(in).MetricData, 100)
+}
@@ -0,0 +1,67 @@
+package cwpagedmetricput
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// Documented on https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/API_MetricDatum.html under
+// "Dimensions: Array Members: Maximum number of 30 items."
+const maxDimensions = 30
+
+// DimensionConflict controls what happens when a datum already has a dimension named the same as a default dimension
+type DimensionConflict int
+
+const (
+	// CallerWins keeps the datum's own value for the dimension.  It is the default.
+	CallerWins DimensionConflict = iota
+	// DefaultWins replaces the datum's value with the default dimension's value
+	DefaultWins
+)
+
+// withDefaultDimensions returns a copy of datum with defaults merged into its dimensions.  The datum itself is never
+// modified.  Defaults that would push the datum past CloudWatch's dimension limit are left off.
+func withDefaultDimensions(datum *cloudwatch.MetricDatum, defaults []*cloudwatch.Dimension, conflict DimensionConflict) *cloudwatch.MetricDatum {
+	if datum == nil || len(defaults) == 0 {
+		return datum
+	}
+	dims := make([]*cloudwatch.Dimension, len(datum.Dimensions), len(datum.Dimensions)+len(defaults))
+	copy(dims, datum.Dimensions)
+	existing := make(map[string]int, len(dims))
+	for i, d := range dims {
+		if d != nil {
+			existing[aws.StringValue(d.Name)] = i
+		}
+	}
+	for _, def := range defaults {
+		if def == nil {
+			continue
+		}
+		if idx, exists := existing[aws.StringValue(def.Name)]; exists {
+			if conflict == DefaultWins {
+				dims[idx] = def
+			}
+			continue
+		}
+		if len(dims) >= maxDimensions {
+			continue
+		}
+		existing[aws.StringValue(def.Name)] = len(dims)
+		dims = append(dims, def)
+	}
+	ret := *datum
+	ret.Dimensions = dims
+	return &ret
+}
+
+// defaultDimensionsStage returns the Stage that applies the config's DefaultDimensions, or nil if there are none
+func (c *Config) defaultDimensionsStage() Stage {
+	if len(c.DefaultDimensions) == 0 {
+		return nil
+	}
+	defaults := c.DefaultDimensions
+	conflict := c.DefaultDimensionConflict
+	return Mapper(func(datum *cloudwatch.MetricDatum) []*cloudwatch.MetricDatum {
+		return []*cloudwatch.MetricDatum{withDefaultDimensions(datum, defaults, conflict)}
+	})
+}
@@ -0,0 +1,89 @@
+package cwpagedmetricput
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+func dim(name string, value string) *cloudwatch.Dimension {
+	return &cloudwatch.Dimension{Name: aws.String(name), Value: aws.String(value)}
+}
+
+func dimMap(dims []*cloudwatch.Dimension) map[string]string {
+	ret := make(map[string]string, len(dims))
+	for _, d := range dims {
+		ret[*d.Name] = *d.Value
+	}
+	return ret
+}
+
+func Test_withDefaultDimensions(t *testing.T) {
+	defaults := []*cloudwatch.Dimension{dim("Service", "svc"), dim("Host", "h1")}
+	manyDims := make([]*cloudwatch.Dimension, 0, maxDimensions-1)
+	for i := 0; i < maxDimensions-1; i++ {
+		manyDims = append(manyDims, dim(fmt.Sprintf("dim%d", i), "v"))
+	}
+	tests := []struct {
+		name     string
+		datum    *cloudwatch.MetricDatum
+		conflict DimensionConflict
+		want     map[string]string
+	}{
+		{
+			name:  "no_dims",
+			datum: &cloudwatch.MetricDatum{},
+			want:  map[string]string{"Service": "svc", "Host": "h1"},
+		},
+		{
+			name:  "caller_wins",
+			datum: &cloudwatch.MetricDatum{Dimensions: []*cloudwatch.Dimension{dim("Host", "mine")}},
+			want:  map[string]string{"Service": "svc", "Host": "mine"},
+		},
+		{
+			name:     "default_wins",
+			datum:    &cloudwatch.MetricDatum{Dimensions: []*cloudwatch.Dimension{dim("Host", "mine")}},
+			conflict: DefaultWins,
+			want:     map[string]string{"Service": "svc", "Host": "h1"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(tt.datum.Dimensions)
+			out := withDefaultDimensions(tt.datum, defaults, tt.conflict)
+			require.Equal(t, tt.want, dimMap(out.Dimensions))
+			require.Len(t, tt.datum.Dimensions, before)
+		})
+	}
+	t.Run("limit", func(t *testing.T) {
+		out := withDefaultDimensions(&cloudwatch.MetricDatum{Dimensions: manyDims}, defaults, CallerWins)
+		require.Len(t, out.Dimensions, maxDimensions)
+		require.Equal(t, "svc", dimMap(out.Dimensions)["Service"])
+		require.Len(t, manyDims, maxDimensions-1)
+	})
+	t.Run("nil", func(t *testing.T) {
+		require.Nil(t, withDefaultDimensions(nil, defaults, CallerWins))
+	})
+}
+
+func TestPager_DefaultDimensions(t *testing.T) {
+	client := &memoryCloudWatchClient{}
+	p := Pager{
+		Client: client,
+		Config: Config{
+			DefaultDimensions: []*cloudwatch.Dimension{dim("Stage", "prod")},
+		},
+	}
+	datum := &cloudwatch.MetricDatum{MetricName: aws.String("m"), Value: aws.Float64(1)}
+	_, err := p.PutMetricData(&cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String("ns"),
+		MetricData: []*cloudwatch.MetricDatum{datum},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"Stage": "prod"}, dimMap(client.in[0].MetricData[0].Dimensions))
+	require.Empty(t, datum.Dimensions)
+}
@@ -0,0 +1,354 @@
+package cwpagedmetricput
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// Documented on https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+const (
+	// emfMaxMetrics is the most metrics a single EMF document may hold
+	emfMaxMetrics = 100
+	// emfMaxValues is the most values a single metric of an EMF document may hold
+	emfMaxValues = 100
+)
+
+const defaultEMFMaxSamples = 1000
+
+// EMFClient is a CloudWatchClient that writes PutMetricDataInput as CloudWatch embedded metric format (EMF) log lines
+// instead of calling the API.  In Lambda or ECS, writing EMF to stdout is cheaper than PutMetricData.  Use it as the
+// Client of a Pager so one code path can target either the API or logs.
+//
+// EMF has no Counts or StatisticValues, so counts are expanded by repeating each value and a StatisticSet is expanded
+// into samples with the same count, minimum, maximum, and sum.  A StatisticSet is only approximated: every sample but
+// the minimum and maximum is the mean of the rest, clamped to the minimum and maximum, so the sum is off when the set is
+// inconsistent.  Datum that cannot be written are skipped and returned as an error, after everything else is written.
+type EMFClient struct {
+	// Writer receives one JSON document per line.  It is required.
+	Writer io.Writer
+	// MaxSamples limits how many values expanding a single datum's Counts or StatisticValues may create.  A datum past
+	// the limit is scaled down to MaxSamples values, keeping the shape of its distribution and its mean.  Defaults to
+	// 1000.
+	MaxSamples int
+	// OnDroppedSamples, if set, is called with each datum MaxSamples scaled down and how many samples were dropped
+	OnDroppedSamples func(datum *cloudwatch.MetricDatum, dropped int)
+
+	// now is time.Now, but can be replaced for testing
+	now func() time.Time
+	mu  sync.Mutex
+}
+
+var _ CloudWatchClient = &EMFClient{}
+
+// PutMetricDataWithContext writes in to Writer as EMF documents.  Request options are ignored.
+func (e *EMFClient) PutMetricDataWithContext(_ aws.Context, in *cloudwatch.PutMetricDataInput, _ ...request.Option) (*cloudwatch.PutMetricDataOutput, error) {
+	if in == nil {
+		return nil, fmt.Errorf("nil PutMetricDataInput")
+	}
+	docs, errs := e.documents(in)
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		b, err := doc.MarshalJSON()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	if buf.Len() > 0 {
+		e.mu.Lock()
+		_, err := e.Writer.Write(buf.Bytes())
+		e.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := consolidateErr(errs); err != nil {
+		return nil, err
+	}
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func (e *EMFClient) maxSamples() int {
+	if e.MaxSamples <= 0 {
+		return defaultEMFMaxSamples
+	}
+	return e.MaxSamples
+}
+
+func (e *EMFClient) currentTime() time.Time {
+	if e.now != nil {
+		return e.now()
+	}
+	return time.Now()
+}
+
+// emfEntry is a single metric of an EMF document, with at most emfMaxValues values
+type emfEntry struct {
+	datum  *cloudwatch.MetricDatum
+	values []float64
+}
+
+// emfDocument is the metrics sharing one timestamp and set of dimensions that fit in a single EMF log line
+type emfDocument struct {
+	namespace  string
+	timestamp  int64
+	dimensions []*cloudwatch.Dimension
+	entries    []emfEntry
+	names      map[string]struct{}
+}
+
+// documents splits in into EMF documents, returning an error for each datum it cannot write
+func (e *EMFClient) documents(in *cloudwatch.PutMetricDataInput) ([]*emfDocument, []error) {
+	var errs []error
+	var docs []*emfDocument
+	open := make(map[string][]*emfDocument)
+	now := e.currentTime()
+	for _, d := range in.MetricData {
+		if d == nil {
+			continue
+		}
+		if len(d.Dimensions) > maxDimensions {
+			errs = append(errs, fmt.Errorf("emf: metric %s has %d dimensions, more than %d", aws.StringValue(d.MetricName), len(d.Dimensions), maxDimensions))
+			continue
+		}
+		if hasDimension(d, aws.StringValue(d.MetricName)) {
+			errs = append(errs, fmt.Errorf("emf: metric %s has the same name as one of its dimensions", aws.StringValue(d.MetricName)))
+			continue
+		}
+		samples, dropped, err := emfSamples(d, e.maxSamples())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if dropped > 0 && e.OnDroppedSamples != nil {
+			e.OnDroppedSamples(d, dropped)
+		}
+		timestamp := now
+		if d.Timestamp != nil {
+			timestamp = *d.Timestamp
+		}
+		millis := timestamp.UnixNano() / int64(time.Millisecond)
+		key := emfDocumentKey(millis, d.Dimensions)
+		for _, values := range splitSamples(samples) {
+			entry := emfEntry{datum: d, values: values}
+			doc := findEMFDocument(open[key], aws.StringValue(d.MetricName))
+			if doc == nil {
+				doc = &emfDocument{
+					namespace:  aws.StringValue(in.Namespace),
+					timestamp:  millis,
+					dimensions: d.Dimensions,
+					names:      make(map[string]struct{}),
+				}
+				open[key] = append(open[key], doc)
+				docs = append(docs, doc)
+			}
+			doc.entries = append(doc.entries, entry)
+			doc.names[aws.StringValue(d.MetricName)] = struct{}{}
+		}
+	}
+	return docs, errs
+}
+
+// hasDimension returns true if d has a dimension called name.  Metrics and dimensions are both keys of an EMF
+// document, so they cannot share a name.
+func hasDimension(d *cloudwatch.MetricDatum, name string) bool {
+	for _, dim := range d.Dimensions {
+		if dim != nil && aws.StringValue(dim.Name) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// findEMFDocument returns the first document with room for a metric named name, or nil if there is none.  A metric
+// name can only be in a document once, since it is a key of the log line.
+func findEMFDocument(docs []*emfDocument, name string) *emfDocument {
+	for _, doc := range docs {
+		if _, exists := doc.names[name]; exists {
+			continue
+		}
+		if len(doc.entries) < emfMaxMetrics {
+			return doc
+		}
+	}
+	return nil
+}
+
+// emfDocumentKey is the same for datum that may share a document: the same timestamp and dimensions
+func emfDocumentKey(millis int64, dims []*cloudwatch.Dimension) string {
+	parts := make([]string, 0, len(dims)+1)
+	for _, dim := range dims {
+		if dim != nil {
+			parts = append(parts, aws.StringValue(dim.Name)+"="+aws.StringValue(dim.Value))
+		}
+	}
+	sort.Strings(parts)
+	return fmt.Sprintf("%d\x00%s", millis, strings.Join(parts, "\x00"))
+}
+
+// splitSamples is splitLargeValueArray for EMF: it splits samples into groups of at most emfMaxValues
+func splitSamples(samples []float64) [][]float64 {
+	ret := make([][]float64, 0, 1+len(samples)/emfMaxValues)
+	for len(samples) > emfMaxValues {
+		ret = append(ret, samples[0:emfMaxValues])
+		samples = samples[emfMaxValues:]
+	}
+	return append(ret, samples)
+}
+
+// emfSamples returns the values EMF should record for d, and how many samples were dropped to fit under maxSamples
+func emfSamples(d *cloudwatch.MetricDatum, maxSamples int) ([]float64, int, error) {
+	switch {
+	case len(d.Values) != 0:
+		return expandCounts(d, maxSamples)
+	case d.StatisticValues != nil:
+		return expandStatisticSet(d, maxSamples)
+	case d.Value != nil:
+		return []float64{*d.Value}, 0, nil
+	default:
+		return nil, 0, fmt.Errorf("emf: metric %s has no value", aws.StringValue(d.MetricName))
+	}
+}
+
+// expandCounts repeats each of d's Values by its count.  Past maxSamples, every count is scaled down by the same ratio.
+func expandCounts(d *cloudwatch.MetricDatum, maxSamples int) ([]float64, int, error) {
+	if d.Counts != nil && len(d.Counts) != len(d.Values) {
+		return nil, 0, fmt.Errorf("emf: metric %s has %d values but %d counts", aws.StringValue(d.MetricName), len(d.Values), len(d.Counts))
+	}
+	counts := make([]float64, len(d.Values))
+	total := 0.0
+	for i := range d.Values {
+		counts[i] = 1
+		if d.Counts != nil {
+			counts[i] = math.Max(math.Round(aws.Float64Value(d.Counts[i])), 0)
+		}
+		if math.IsNaN(counts[i]) || math.IsInf(counts[i], 0) {
+			return nil, 0, fmt.Errorf("emf: metric %s has an invalid count", aws.StringValue(d.MetricName))
+		}
+		total += counts[i]
+	}
+	// Scale as floats, so huge counts never overflow an int
+	scale := 1.0
+	if total > float64(maxSamples) {
+		scale = float64(maxSamples) / total
+	}
+	ret := make([]float64, 0, len(d.Values))
+	for i, v := range d.Values {
+		for j := 0; j < int(counts[i]*scale); j++ {
+			ret = append(ret, aws.Float64Value(v))
+		}
+	}
+	return ret, droppedSamples(total, len(ret)), nil
+}
+
+// expandStatisticSet turns d's StatisticValues into samples with the same count, minimum, maximum, and sum.  Past
+// maxSamples, the count and sum are scaled down together so the mean is kept.
+func expandStatisticSet(d *cloudwatch.MetricDatum, maxSamples int) ([]float64, int, error) {
+	s := d.StatisticValues
+	if s.SampleCount == nil || s.Sum == nil || s.Minimum == nil || s.Maximum == nil {
+		return nil, 0, fmt.Errorf("emf: metric %s has an incomplete StatisticSet", aws.StringValue(d.MetricName))
+	}
+	total := math.Round(*s.SampleCount)
+	if math.IsNaN(total) || math.IsInf(total, 0) {
+		return nil, 0, fmt.Errorf("emf: metric %s has an invalid sample count", aws.StringValue(d.MetricName))
+	}
+	sum := *s.Sum
+	dropped := 0
+	if total > float64(maxSamples) {
+		sum = sum * float64(maxSamples) / total
+		dropped = droppedSamples(total, maxSamples)
+		total = float64(maxSamples)
+	}
+	count := int(total)
+	switch {
+	case count <= 0:
+		return nil, 0, fmt.Errorf("emf: metric %s has no samples", aws.StringValue(d.MetricName))
+	case count == 1:
+		return []float64{sum}, dropped, nil
+	case count == 2:
+		return []float64{*s.Minimum, *s.Maximum}, dropped, nil
+	}
+	ret := make([]float64, 0, count)
+	ret = append(ret, *s.Minimum, *s.Maximum)
+	// An inconsistent StatisticSet could put the rest outside the minimum and maximum
+	rest := math.Min(math.Max((sum-*s.Minimum-*s.Maximum)/float64(count-2), *s.Minimum), *s.Maximum)
+	for len(ret) < count {
+		ret = append(ret, rest)
+	}
+	return ret, dropped, nil
+}
+
+// droppedSamples returns how many of total samples were dropped to keep kept, capped so it always fits in an int
+func droppedSamples(total float64, kept int) int {
+	dropped := total - float64(kept)
+	if dropped > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int(dropped)
+}
+
+// emfMetadata is the _aws member of an EMF document
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetric struct {
+	Name              string `json:"Name"`
+	Unit              string `json:"Unit,omitempty"`
+	StorageResolution int64  `json:"StorageResolution,omitempty"`
+}
+
+// MarshalJSON returns the document as a single line of JSON
+func (d *emfDocument) MarshalJSON() ([]byte, error) {
+	directive := emfDirective{
+		Namespace:  d.namespace,
+		Dimensions: [][]string{make([]string, 0, len(d.dimensions))},
+		Metrics:    make([]emfMetric, 0, len(d.entries)),
+	}
+	members := make(map[string]interface{}, len(d.dimensions)+len(d.entries)+1)
+	for _, dim := range d.dimensions {
+		if dim == nil {
+			continue
+		}
+		directive.Dimensions[0] = append(directive.Dimensions[0], aws.StringValue(dim.Name))
+		members[aws.StringValue(dim.Name)] = aws.StringValue(dim.Value)
+	}
+	for _, entry := range d.entries {
+		name := aws.StringValue(entry.datum.MetricName)
+		directive.Metrics = append(directive.Metrics, emfMetric{
+			Name:              name,
+			Unit:              aws.StringValue(filterInvalidUnit(entry.datum.Unit)),
+			StorageResolution: aws.Int64Value(entry.datum.StorageResolution),
+		})
+		if len(entry.values) == 1 {
+			members[name] = entry.values[0]
+		} else {
+			members[name] = entry.values
+		}
+	}
+	members["_aws"] = emfMetadata{
+		Timestamp:         d.timestamp,
+		CloudWatchMetrics: []emfDirective{directive},
+	}
+	return json.Marshal(members)
+}
@@ -0,0 +1,349 @@
+package cwpagedmetricput
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+// emfLines decodes every EMF document written to buf
+func emfLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var ret []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var doc map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &doc))
+		ret = append(ret, doc)
+	}
+	return ret
+}
+
+// emfMetrics returns the metric names declared by an EMF document
+func emfMetrics(doc map[string]interface{}) []string {
+	var ret []string
+	directive := doc["_aws"].(map[string]interface{})["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+	for _, m := range directive["Metrics"].([]interface{}) {
+		ret = append(ret, m.(map[string]interface{})["Name"].(string))
+	}
+	return ret
+}
+
+func TestEMFClient(t *testing.T) {
+	now := time.Unix(1000, 0)
+	newClient := func() (*EMFClient, *bytes.Buffer) {
+		var buf bytes.Buffer
+		return &EMFClient{Writer: &buf, now: func() time.Time { return now }}, &buf
+	}
+	t.Run("single", func(t *testing.T) {
+		client, buf := newClient()
+		_, err := client.PutMetricDataWithContext(context.Background(), &cloudwatch.PutMetricDataInput{
+			Namespace: aws.String("ns"),
+			MetricData: []*cloudwatch.MetricDatum{
+				{
+					MetricName:        aws.String("latency"),
+					Unit:              aws.String("Milliseconds"),
+					StorageResolution: aws.Int64(1),
+					Value:             aws.Float64(12),
+					Dimensions:        []*cloudwatch.Dimension{dim("host", "a")},
+				},
+			},
+		})
+		require.NoError(t, err)
+		docs := emfLines(t, buf)
+		require.Len(t, docs, 1)
+		require.Equal(t, map[string]interface{}{
+			"_aws": map[string]interface{}{
+				"Timestamp": float64(1000000),
+				"CloudWatchMetrics": []interface{}{
+					map[string]interface{}{
+						"Namespace":  "ns",
+						"Dimensions": []interface{}{[]interface{}{"host"}},
+						"Metrics": []interface{}{
+							map[string]interface{}{"Name": "latency", "Unit": "Milliseconds", "StorageResolution": float64(1)},
+						},
+					},
+				},
+			},
+			"host":    "a",
+			"latency": float64(12),
+		}, docs[0])
+	})
+	t.Run("groups by dimensions", func(t *testing.T) {
+		client, buf := newClient()
+		in := &cloudwatch.PutMetricDataInput{Namespace: aws.String("ns")}
+		for i := 0; i < 250; i++ {
+			in.MetricData = append(in.MetricData, &cloudwatch.MetricDatum{
+				MetricName: aws.String(fmt.Sprintf("m%d", i)),
+				Value:      aws.Float64(1),
+				Dimensions: []*cloudwatch.Dimension{dim("host", fmt.Sprintf("%d", i%2))},
+			})
+		}
+		_, err := client.PutMetricDataWithContext(context.Background(), in)
+		require.NoError(t, err)
+		docs := emfLines(t, buf)
+		// 125 metrics per host, at most 100 per document
+		require.Len(t, docs, 4)
+		total := 0
+		for _, doc := range docs {
+			metrics := emfMetrics(doc)
+			require.True(t, len(metrics) <= emfMaxMetrics)
+			total += len(metrics)
+		}
+		require.Equal(t, 250, total)
+	})
+	t.Run("same metric twice", func(t *testing.T) {
+		client, buf := newClient()
+		_, err := client.PutMetricDataWithContext(context.Background(), &cloudwatch.PutMetricDataInput{
+			Namespace: aws.String("ns"),
+			MetricData: []*cloudwatch.MetricDatum{
+				{MetricName: aws.String("m"), Value: aws.Float64(1)},
+				{MetricName: aws.String("m"), Value: aws.Float64(2)},
+			},
+		})
+		require.NoError(t, err)
+		docs := emfLines(t, buf)
+		require.Len(t, docs, 2)
+		require.Equal(t, float64(1), docs[0]["m"])
+		require.Equal(t, float64(2), docs[1]["m"])
+	})
+	t.Run("splits values", func(t *testing.T) {
+		client, buf := newClient()
+		d := &cloudwatch.MetricDatum{MetricName: aws.String("m")}
+		for i := 0; i < 250; i++ {
+			d.Values = append(d.Values, aws.Float64(float64(i)))
+		}
+		_, err := client.PutMetricDataWithContext(context.Background(), &cloudwatch.PutMetricDataInput{Namespace: aws.String("ns"), MetricData: []*cloudwatch.MetricDatum{d}})
+		require.NoError(t, err)
+		docs := emfLines(t, buf)
+		require.Len(t, docs, 3)
+		require.Len(t, docs[0]["m"], 100)
+		require.Len(t, docs[2]["m"], 50)
+	})
+	t.Run("errors", func(t *testing.T) {
+		client, buf := newClient()
+		client.MaxSamples = 10
+		_, err := client.PutMetricDataWithContext(context.Background(), &cloudwatch.PutMetricDataInput{
+			Namespace: aws.String("ns"),
+			MetricData: []*cloudwatch.MetricDatum{
+				{MetricName: aws.String("good"), Value: aws.Float64(1)},
+				{MetricName: aws.String("novalue")},
+				{MetricName: aws.String("host"), Value: aws.Float64(1), Dimensions: []*cloudwatch.Dimension{dim("host", "a")}},
+			},
+		})
+		require.Error(t, err)
+		docs := emfLines(t, buf)
+		require.Len(t, docs, 1)
+		require.Equal(t, []string{"good"}, emfMetrics(docs[0]))
+	})
+	t.Run("max samples", func(t *testing.T) {
+		client, buf := newClient()
+		client.MaxSamples = 10
+		dropped := map[string]int{}
+		client.OnDroppedSamples = func(d *cloudwatch.MetricDatum, n int) {
+			dropped[*d.MetricName] = n
+		}
+		_, err := client.PutMetricDataWithContext(context.Background(), &cloudwatch.PutMetricDataInput{
+			Namespace: aws.String("ns"),
+			MetricData: []*cloudwatch.MetricDatum{
+				{MetricName: aws.String("few"), Values: []*float64{aws.Float64(1)}, Counts: []*float64{aws.Float64(10)}},
+				{MetricName: aws.String("many"), Values: []*float64{aws.Float64(1), aws.Float64(2)}, Counts: []*float64{aws.Float64(15), aws.Float64(5)}},
+			},
+		})
+		require.NoError(t, err)
+		docs := emfLines(t, buf)
+		require.Len(t, docs, 1)
+		require.Len(t, docs[0]["few"], 10)
+		require.Len(t, docs[0]["many"], 9)
+		require.Equal(t, map[string]int{"many": 11}, dropped)
+	})
+	t.Run("default max samples", func(t *testing.T) {
+		client, buf := newClient()
+		dropped := 0
+		client.OnDroppedSamples = func(d *cloudwatch.MetricDatum, n int) {
+			dropped = n
+		}
+		_, err := client.PutMetricDataWithContext(context.Background(), &cloudwatch.PutMetricDataInput{
+			Namespace: aws.String("ns"),
+			MetricData: []*cloudwatch.MetricDatum{
+				{MetricName: aws.String("m"), Values: []*float64{aws.Float64(1)}, Counts: []*float64{aws.Float64(2500)}},
+			},
+		})
+		require.NoError(t, err)
+		total := 0
+		for _, doc := range emfLines(t, buf) {
+			total += len(doc["m"].([]interface{}))
+		}
+		require.Equal(t, defaultEMFMaxSamples, total)
+		require.Equal(t, 2500-defaultEMFMaxSamples, dropped)
+	})
+	t.Run("with pager", func(t *testing.T) {
+		client, buf := newClient()
+		p := &Pager{Client: client}
+		in := &cloudwatch.PutMetricDataInput{Namespace: aws.String("ns")}
+		for i := 0; i < 45; i++ {
+			in.MetricData = append(in.MetricData, &cloudwatch.MetricDatum{MetricName: aws.String(fmt.Sprintf("m%d", i)), Value: aws.Float64(1)})
+		}
+		_, err := p.PutMetricData(in)
+		require.NoError(t, err)
+		total := 0
+		for _, doc := range emfLines(t, buf) {
+			total += len(emfMetrics(doc))
+		}
+		require.Equal(t, 45, total)
+	})
+}
+
+func Test_emfSamples(t *testing.T) {
+	tests := []struct {
+		name        string
+		datum       *cloudwatch.MetricDatum
+		maxSamples  int
+		want        []float64
+		wantDropped int
+	}{
+		{
+			name:  "value",
+			datum: &cloudwatch.MetricDatum{Value: aws.Float64(3)},
+			want:  []float64{3},
+		},
+		{
+			name:  "values",
+			datum: &cloudwatch.MetricDatum{Values: []*float64{aws.Float64(1), aws.Float64(2)}},
+			want:  []float64{1, 2},
+		},
+		{
+			name: "counts",
+			datum: &cloudwatch.MetricDatum{
+				Values: []*float64{aws.Float64(1), aws.Float64(2)},
+				Counts: []*float64{aws.Float64(2), aws.Float64(3)},
+			},
+			want: []float64{1, 1, 2, 2, 2},
+		},
+		{
+			name: "statistic set",
+			datum: &cloudwatch.MetricDatum{StatisticValues: &cloudwatch.StatisticSet{
+				SampleCount: aws.Float64(4),
+				Sum:         aws.Float64(20),
+				Minimum:     aws.Float64(1),
+				Maximum:     aws.Float64(9),
+			}},
+			want: []float64{1, 9, 5, 5},
+		},
+		{
+			name: "single sample",
+			datum: &cloudwatch.MetricDatum{StatisticValues: &cloudwatch.StatisticSet{
+				SampleCount: aws.Float64(1),
+				Sum:         aws.Float64(7),
+				Minimum:     aws.Float64(7),
+				Maximum:     aws.Float64(7),
+			}},
+			want: []float64{7},
+		},
+		{
+			name: "inconsistent statistic set",
+			datum: &cloudwatch.MetricDatum{StatisticValues: &cloudwatch.StatisticSet{
+				SampleCount: aws.Float64(4),
+				Sum:         aws.Float64(100),
+				Minimum:     aws.Float64(1),
+				Maximum:     aws.Float64(9),
+			}},
+			want: []float64{1, 9, 9, 9},
+		},
+		{
+			name: "scaled statistic set",
+			datum: &cloudwatch.MetricDatum{StatisticValues: &cloudwatch.StatisticSet{
+				SampleCount: aws.Float64(8),
+				Sum:         aws.Float64(40),
+				Minimum:     aws.Float64(1),
+				Maximum:     aws.Float64(9),
+			}},
+			maxSamples:  4,
+			want:        []float64{1, 9, 5, 5},
+			wantDropped: 4,
+		},
+		{
+			name: "scaled counts",
+			datum: &cloudwatch.MetricDatum{
+				Values: []*float64{aws.Float64(1), aws.Float64(2)},
+				Counts: []*float64{aws.Float64(4), aws.Float64(8)},
+			},
+			maxSamples:  6,
+			want:        []float64{1, 1, 2, 2, 2, 2},
+			wantDropped: 6,
+		},
+		{
+			name: "huge statistic set",
+			datum: &cloudwatch.MetricDatum{StatisticValues: &cloudwatch.StatisticSet{
+				SampleCount: aws.Float64(1e300),
+				Sum:         aws.Float64(2e300),
+				Minimum:     aws.Float64(1),
+				Maximum:     aws.Float64(3),
+			}},
+			maxSamples:  3,
+			want:        []float64{1, 3, 2},
+			wantDropped: math.MaxInt32,
+		},
+		{
+			name: "huge counts",
+			datum: &cloudwatch.MetricDatum{
+				Values: []*float64{aws.Float64(1)},
+				Counts: []*float64{aws.Float64(5e7)},
+			},
+			maxSamples:  2,
+			want:        []float64{1, 1},
+			wantDropped: 5e7 - 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxSamples := tt.maxSamples
+			if maxSamples == 0 {
+				maxSamples = defaultEMFMaxSamples
+			}
+			got, dropped, err := emfSamples(tt.datum, maxSamples)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.wantDropped, dropped)
+		})
+	}
+}
+
+func Test_emfSamples_errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		datum *cloudwatch.MetricDatum
+	}{
+		{name: "no value", datum: &cloudwatch.MetricDatum{}},
+		{name: "infinite count", datum: &cloudwatch.MetricDatum{Values: []*float64{aws.Float64(1)}, Counts: []*float64{aws.Float64(math.Inf(1))}}},
+		{name: "NaN count", datum: &cloudwatch.MetricDatum{Values: []*float64{aws.Float64(1)}, Counts: []*float64{aws.Float64(math.NaN())}}},
+		{name: "infinite sample count", datum: &cloudwatch.MetricDatum{StatisticValues: &cloudwatch.StatisticSet{
+			SampleCount: aws.Float64(math.Inf(1)),
+			Sum:         aws.Float64(1),
+			Minimum:     aws.Float64(1),
+			Maximum:     aws.Float64(1),
+		}}},
+		{name: "zero sample count", datum: &cloudwatch.MetricDatum{StatisticValues: &cloudwatch.StatisticSet{
+			SampleCount: aws.Float64(0),
+			Sum:         aws.Float64(1),
+			Minimum:     aws.Float64(1),
+			Maximum:     aws.Float64(1),
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := emfSamples(tt.datum, defaultEMFMaxSamples)
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package cwpagedmetricput
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// emfMaxLineSize is the largest EMF document EMFPublisher reads.  CloudWatch Logs events are at most 1MB.
+const emfMaxLineSize = 1024 * 1024
+
+const defaultEMFBatchSize = 1000
+
+// errNotEMF is returned by ParseEMF for JSON that has no _aws member
+var errNotEMF = errors.New("emf: document has no _aws metadata")
+
+// emfDocumentIn is an EMF document as it is read.  Every other member of the document is a metric or dimension value.
+type emfDocumentIn struct {
+	AWS *struct {
+		Timestamp         *int64         `json:"Timestamp"`
+		CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+	} `json:"_aws"`
+}
+
+// ParseEMF decodes a single embedded metric format (EMF) document into the PutMetricDataInput of each of its
+// directives.  A metric is published once for each of its directive's dimension sets, just like CloudWatch Logs
+// extracts it.
+func ParseEMF(doc []byte) ([]*cloudwatch.PutMetricDataInput, error) {
+	var parsed emfDocumentIn
+	if err := json.Unmarshal(doc, &parsed); err != nil {
+		return nil, err
+	}
+	if parsed.AWS == nil {
+		return nil, errNotEMF
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &members); err != nil {
+		return nil, err
+	}
+	if parsed.AWS.Timestamp == nil {
+		return nil, errors.New("emf: document has no timestamp")
+	}
+	timestamp := time.Unix(0, *parsed.AWS.Timestamp*int64(time.Millisecond))
+
+	ret := make([]*cloudwatch.PutMetricDataInput, 0, len(parsed.AWS.CloudWatchMetrics))
+	for _, directive := range parsed.AWS.CloudWatchMetrics {
+		in := &cloudwatch.PutMetricDataInput{
+			Namespace: aws.String(directive.Namespace),
+		}
+		dimensionSets := directive.Dimensions
+		if len(dimensionSets) == 0 {
+			dimensionSets = [][]string{{}}
+		}
+		for _, m := range directive.Metrics {
+			value, exists := members[m.Name]
+			if !exists {
+				return nil, fmt.Errorf("emf: metric %s has no value", m.Name)
+			}
+			template, err := emfDatum(m, value)
+			if err != nil {
+				return nil, err
+			}
+			template.Timestamp = &timestamp
+			for _, dimensionSet := range dimensionSets {
+				d := *template
+				if d.Dimensions, err = emfDimensions(dimensionSet, members); err != nil {
+					return nil, err
+				}
+				in.MetricData = append(in.MetricData, &d)
+			}
+		}
+		ret = append(ret, in)
+	}
+	return ret, nil
+}
+
+// emfDatum returns a datum for a metric of an EMF document, without dimensions or a timestamp
+func emfDatum(m emfMetric, value json.RawMessage) (*cloudwatch.MetricDatum, error) {
+	ret := &cloudwatch.MetricDatum{
+		MetricName: aws.String(m.Name),
+	}
+	if m.Unit != "" {
+		ret.Unit = aws.String(m.Unit)
+	}
+	if m.StorageResolution != 0 {
+		ret.StorageResolution = aws.Int64(m.StorageResolution)
+	}
+	var single float64
+	if err := json.Unmarshal(value, &single); err == nil {
+		ret.Value = aws.Float64(single)
+		return ret, nil
+	}
+	var values []float64
+	if err := json.Unmarshal(value, &values); err != nil {
+		return nil, fmt.Errorf("emf: metric %s is not a number or array of numbers", m.Name)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("emf: metric %s has no values", m.Name)
+	}
+	ret.Values = make([]*float64, len(values))
+	for i := range values {
+		ret.Values[i] = &values[i]
+	}
+	return ret, nil
+}
+
+// emfDimensions looks up the value of each dimension of a dimension set
+func emfDimensions(names []string, members map[string]json.RawMessage) ([]*cloudwatch.Dimension, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	ret := make([]*cloudwatch.Dimension, 0, len(names))
+	for _, name := range names {
+		raw, exists := members[name]
+		if !exists {
+			return nil, fmt.Errorf("emf: dimension %s has no value", name)
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			// Not a string, so use the JSON as written, like 200 or true
+			value = string(bytes.TrimSpace(raw))
+		}
+		ret = append(ret, &cloudwatch.Dimension{
+			Name:  aws.String(name),
+			Value: aws.String(value),
+		})
+	}
+	return ret, nil
+}
+
+// EMFPublisher reads embedded metric format (EMF) documents and publishes their metrics, for example to backfill
+// metrics from logs that CloudWatch Logs did not extract.
+type EMFPublisher struct {
+	// Client receives the metrics.  It is required and is usually a *Pager.
+	Client CloudWatchClient
+	// BatchSize is how many datum of a namespace are buffered before they are published.  Defaults to 1000.
+	BatchSize int
+	// OnError, if set, is called with the line number and error of each line that looks like JSON but is not a valid
+	// EMF document.  Lines that do not contain a JSON object are skipped without calling OnError.
+	OnError func(line int, err error)
+}
+
+func (e *EMFPublisher) batchSize() int {
+	if e.BatchSize <= 0 {
+		return defaultEMFBatchSize
+	}
+	return e.BatchSize
+}
+
+// Publish reads r, one EMF document per line, and publishes every metric.  Log lines with a prefix before the
+// document, like a timestamp, are allowed.  It returns the first error from reading r or from Client.
+func (e *EMFPublisher) Publish(ctx context.Context, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), emfMaxLineSize)
+	pending := make(map[string]*cloudwatch.PutMetricDataInput)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Bytes()
+		start := bytes.IndexByte(line, '{')
+		if start == -1 {
+			continue
+		}
+		inputs, err := ParseEMF(line[start:])
+		if err != nil {
+			if e.OnError != nil {
+				e.OnError(lineNumber, err)
+			}
+			continue
+		}
+		for _, in := range inputs {
+			ns := aws.StringValue(in.Namespace)
+			p, exists := pending[ns]
+			if !exists {
+				p = &cloudwatch.PutMetricDataInput{Namespace: in.Namespace}
+				pending[ns] = p
+			}
+			p.MetricData = append(p.MetricData, in.MetricData...)
+			if len(p.MetricData) >= e.batchSize() {
+				delete(pending, ns)
+				if _, err := e.Client.PutMetricDataWithContext(ctx, p); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	// Publish what is left in a stable order
+	namespaces := make([]string, 0, len(pending))
+	for ns := range pending {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	for _, ns := range namespaces {
+		if _, err := e.Client.PutMetricDataWithContext(ctx, pending[ns]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
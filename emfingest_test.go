@@ -0,0 +1,170 @@
+package cwpagedmetricput
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEMF(t *testing.T) {
+	timestamp := time.Unix(1000, 0)
+	tests := []struct {
+		name    string
+		doc     string
+		want    []*cloudwatch.PutMetricDataInput
+		wantErr bool
+	}{
+		{
+			name: "dimension sets",
+			doc: `{"_aws":{"Timestamp":1000000,"CloudWatchMetrics":[{"Namespace":"ns","Dimensions":[["host"],["host","code"]],` +
+				`"Metrics":[{"Name":"latency","Unit":"Milliseconds","StorageResolution":1}]}]},"host":"a","code":200,"latency":[1,2]}`,
+			want: []*cloudwatch.PutMetricDataInput{
+				{
+					Namespace: aws.String("ns"),
+					MetricData: []*cloudwatch.MetricDatum{
+						{
+							MetricName:        aws.String("latency"),
+							Unit:              aws.String("Milliseconds"),
+							StorageResolution: aws.Int64(1),
+							Timestamp:         &timestamp,
+							Values:            []*float64{aws.Float64(1), aws.Float64(2)},
+							Dimensions:        []*cloudwatch.Dimension{dim("host", "a")},
+						},
+						{
+							MetricName:        aws.String("latency"),
+							Unit:              aws.String("Milliseconds"),
+							StorageResolution: aws.Int64(1),
+							Timestamp:         &timestamp,
+							Values:            []*float64{aws.Float64(1), aws.Float64(2)},
+							Dimensions:        []*cloudwatch.Dimension{dim("host", "a"), dim("code", "200")},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "no dimensions",
+			doc:  `{"_aws":{"Timestamp":1000000,"CloudWatchMetrics":[{"Namespace":"ns","Metrics":[{"Name":"m"}]}]},"m":3}`,
+			want: []*cloudwatch.PutMetricDataInput{
+				{
+					Namespace: aws.String("ns"),
+					MetricData: []*cloudwatch.MetricDatum{
+						{MetricName: aws.String("m"), Timestamp: &timestamp, Value: aws.Float64(3)},
+					},
+				},
+			},
+		},
+		{
+			name:    "not emf",
+			doc:     `{"level":"info"}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing value",
+			doc:     `{"_aws":{"Timestamp":1000000,"CloudWatchMetrics":[{"Namespace":"ns","Metrics":[{"Name":"m"}]}]}}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing dimension",
+			doc:     `{"_aws":{"Timestamp":1000000,"CloudWatchMetrics":[{"Namespace":"ns","Dimensions":[["host"]],"Metrics":[{"Name":"m"}]}]},"m":3}`,
+			wantErr: true,
+		},
+		{
+			name:    "bad value",
+			doc:     `{"_aws":{"Timestamp":1000000,"CloudWatchMetrics":[{"Namespace":"ns","Metrics":[{"Name":"m"}]}]},"m":"three"}`,
+			wantErr: true,
+		},
+		{
+			name:    "no timestamp",
+			doc:     `{"_aws":{"CloudWatchMetrics":[{"Namespace":"ns","Metrics":[{"Name":"m"}]}]},"m":3}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEMF([]byte(tt.doc))
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, got, len(tt.want))
+			for i := range tt.want {
+				require.Equal(t, tt.want[i].GoString(), got[i].GoString())
+			}
+		})
+	}
+}
+
+func TestEMFPublisher(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		var buf bytes.Buffer
+		now := time.Unix(1000, 0)
+		in := &cloudwatch.PutMetricDataInput{
+			Namespace: aws.String("ns"),
+			MetricData: []*cloudwatch.MetricDatum{
+				{MetricName: aws.String("a"), Value: aws.Float64(1), Unit: aws.String("Count"), Dimensions: []*cloudwatch.Dimension{dim("host", "x")}},
+				{MetricName: aws.String("b"), Values: []*float64{aws.Float64(1), aws.Float64(2)}},
+			},
+		}
+		_, err := (&EMFClient{Writer: &buf, now: func() time.Time { return now }}).PutMetricDataWithContext(context.Background(), in)
+		require.NoError(t, err)
+
+		client := &memoryCloudWatchClient{}
+		require.NoError(t, (&EMFPublisher{Client: client}).Publish(context.Background(), &buf))
+		require.Len(t, client.in, 1)
+		require.Len(t, client.in[0].MetricData, 2)
+		byName := make(map[string]*cloudwatch.MetricDatum)
+		for _, d := range client.in[0].MetricData {
+			byName[*d.MetricName] = d
+		}
+		require.Equal(t, "Count", *byName["a"].Unit)
+		require.Equal(t, now, *byName["a"].Timestamp)
+		require.Equal(t, []*cloudwatch.Dimension{dim("host", "x")}, byName["a"].Dimensions)
+		require.Len(t, byName["b"].Values, 2)
+	})
+	t.Run("log lines", func(t *testing.T) {
+		logs := strings.Join([]string{
+			`START RequestId: 1234`,
+			`2019-08-01T00:00:00Z {"_aws":{"Timestamp":1000000,"CloudWatchMetrics":[{"Namespace":"a","Metrics":[{"Name":"m"}]}]},"m":1}`,
+			`{"level":"info","msg":"not a metric"}`,
+			`{"_aws":{"Timestamp":1000000,"CloudWatchMetrics":[{"Namespace":"b","Metrics":[{"Name":"m"}]}]},"m":2}`,
+			`{"_aws":{"Timestamp":1000000,"CloudWatchMetrics":[{"Namespace":"a","Metrics":[{"Name":"m"}]}]},"m":3}`,
+			`{not json`,
+		}, "\n")
+		client := &memoryCloudWatchClient{}
+		var errLines []int
+		p := &EMFPublisher{
+			Client:  client,
+			OnError: func(line int, err error) { errLines = append(errLines, line) },
+		}
+		require.NoError(t, p.Publish(context.Background(), strings.NewReader(logs)))
+		require.Equal(t, []int{3, 6}, errLines)
+		require.Len(t, client.in, 2)
+		require.Equal(t, "a", *client.in[0].Namespace)
+		require.Len(t, client.in[0].MetricData, 2)
+		require.Equal(t, "b", *client.in[1].Namespace)
+	})
+	t.Run("batches", func(t *testing.T) {
+		var logs strings.Builder
+		for i := 0; i < 5; i++ {
+			logs.WriteString(`{"_aws":{"Timestamp":1000000,"CloudWatchMetrics":[{"Namespace":"ns","Metrics":[{"Name":"m"}]}]},"m":1}` + "\n")
+		}
+		client := &memoryCloudWatchClient{}
+		require.NoError(t, (&EMFPublisher{Client: client, BatchSize: 2}).Publish(context.Background(), strings.NewReader(logs.String())))
+		require.Len(t, client.in, 3)
+		require.Len(t, client.in[2].MetricData, 1)
+	})
+	t.Run("client error", func(t *testing.T) {
+		client := &memoryCloudWatchClient{errOnCall: 1, err: errors.New("down")}
+		doc := `{"_aws":{"Timestamp":1000000,"CloudWatchMetrics":[{"Namespace":"ns","Metrics":[{"Name":"m"}]}]},"m":1}`
+		require.Error(t, (&EMFPublisher{Client: client}).Publish(context.Background(), strings.NewReader(doc)))
+	})
+}
@@ -0,0 +1,88 @@
+package cwpagedmetricput
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// BodyEncoder encodes the body of each request before it is sent.  Whatever it produces is still checked against
+// CloudWatch's request size limit.
+type BodyEncoder interface {
+	// ContentEncoding is the value of the Content-Encoding header for encoded bodies, or empty to send no header
+	ContentEncoding() string
+	// Encode writes the encoded form of body to w
+	Encode(w io.Writer, body io.Reader) error
+}
+
+// GzipEncoder is a BodyEncoder that gzips bodies.  It is the default.
+type GzipEncoder struct {
+	// Level is the gzip compression level.  The zero value uses gzip.DefaultCompression.
+	Level int
+}
+
+var _ BodyEncoder = GzipEncoder{}
+
+// ContentEncoding returns gzip
+func (g GzipEncoder) ContentEncoding() string {
+	return "gzip"
+}
+
+// Encode gzips body into w.  gzip writers are pooled, since each holds hundreds of KB of state.
+func (g GzipEncoder) Encode(w io.Writer, body io.Reader) error {
+	level := g.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return fmt.Errorf("gzip: invalid compression level: %d", level)
+	}
+	pool := &gzipWriterPools[level-gzip.HuffmanOnly]
+	gzipW, _ := pool.Get().(*gzip.Writer)
+	if gzipW == nil {
+		var err error
+		if gzipW, err = gzip.NewWriterLevel(w, level); err != nil {
+			return err
+		}
+	} else {
+		gzipW.Reset(w)
+	}
+	defer pool.Put(gzipW)
+	if _, err := pooledCopy(gzipW, body); err != nil {
+		return err
+	}
+	return gzipW.Close()
+}
+
+// gzipWriterPools holds a pool of gzip writers for each level, from gzip.HuffmanOnly to gzip.BestCompression
+var gzipWriterPools [gzip.BestCompression - gzip.HuffmanOnly + 1]sync.Pool
+
+// IdentityEncoder is a BodyEncoder that sends bodies uncompressed, for proxies and emulators that reject
+// Content-Encoding: gzip
+type IdentityEncoder struct{}
+
+var _ BodyEncoder = IdentityEncoder{}
+
+// ContentEncoding is empty, so no header is sent
+func (IdentityEncoder) ContentEncoding() string {
+	return ""
+}
+
+// Encode copies body into w unchanged
+func (IdentityEncoder) Encode(w io.Writer, body io.Reader) error {
+	_, err := pooledCopy(w, body)
+	return err
+}
+
+// encoder returns the BodyEncoder the config asks for
+func (c *Config) encoder() BodyEncoder {
+	switch {
+	case c.Encoder != nil:
+		return c.Encoder
+	case c.DisableCompression:
+		return IdentityEncoder{}
+	default:
+		return GzipEncoder{Level: c.CompressionLevel}
+	}
+}
@@ -0,0 +1,72 @@
+package cwpagedmetricput
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_encodeBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		enc      BodyEncoder
+		arg      *request.Request
+		validate func(r *request.Request)
+	}{
+		{
+			name: "identity",
+			enc:  IdentityEncoder{},
+			arg:  reqWithBody("hello world"),
+			validate: func(r *request.Request) {
+				require.NoError(t, r.Error)
+				require.Equal(t, "", r.HTTPRequest.Header.Get("Content-Encoding"))
+				body, err := ioutil.ReadAll(r.GetBody())
+				require.NoError(t, err)
+				require.Equal(t, "hello world", string(body))
+			},
+		},
+		{
+			name: "identity_too_large",
+			enc:  IdentityEncoder{},
+			arg:  reqWithBody(strings.Repeat("A", 1024*64)),
+			validate: func(r *request.Request) {
+				require.IsType(t, &awsRequestSizeError{}, r.Error)
+			},
+		},
+		{
+			name: "gzip_level",
+			enc:  GzipEncoder{Level: gzip.BestCompression},
+			arg:  reqWithBody(strings.Repeat("A", 1024*64)),
+			validate: func(r *request.Request) {
+				require.NoError(t, r.Error)
+				require.Equal(t, "gzip", r.HTTPRequest.Header.Get("Content-Encoding"))
+			},
+		},
+		{
+			name: "gzip_bad_level",
+			enc:  GzipEncoder{Level: 100},
+			arg:  reqWithBody("hello world"),
+			validate: func(r *request.Request) {
+				require.Error(t, r.Error)
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			encodeBody(tt.arg, tt.enc, putMetricDataKBRequestSizeLimit, nil)
+			tt.validate(tt.arg)
+		})
+	}
+}
+
+func TestConfig_encoder(t *testing.T) {
+	require.Equal(t, GzipEncoder{}, (&Config{}).encoder())
+	require.Equal(t, GzipEncoder{Level: gzip.BestSpeed}, (&Config{CompressionLevel: gzip.BestSpeed}).encoder())
+	require.Equal(t, IdentityEncoder{}, (&Config{DisableCompression: true}).encoder())
+	require.Equal(t, GzipEncoder{Level: 3}, (&Config{DisableCompression: true, Encoder: GzipEncoder{Level: 3}}).encoder())
+}
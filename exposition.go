@@ -0,0 +1,70 @@
+package cwpagedmetricput
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// PublishExpvar publishes the Pager's Stats as an expvar under name.  Like expvar.Publish, it panics if name is
+// already published.
+func PublishExpvar(name string, p *Pager) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return p.Stats()
+	}))
+}
+
+// DefaultPrometheusPrefix prefixes every metric name PrometheusHandler writes
+const DefaultPrometheusPrefix = "cwpagedmetricput"
+
+// PrometheusHandler returns an http.Handler that serves the Pager's Stats in the Prometheus text exposition format
+func PrometheusHandler(p *Pager) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		// Nothing useful can be done if the client went away mid response
+		_ = WritePrometheus(rw, DefaultPrometheusPrefix, p.Stats())
+	})
+}
+
+// WritePrometheus writes stats to w in the Prometheus text exposition format, with every metric name prefixed by
+// prefix
+func WritePrometheus(w io.Writer, prefix string, stats Stats) error {
+	bw := bufio.NewWriter(w)
+	counter := func(name string, help string, value int64) {
+		name = prefix + "_" + name
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+	counter("calls_total", "PutMetricData calls made to the pager.", stats.Calls)
+	counter("datum_in_total", "Datum passed to the pager.", stats.DatumIn)
+	counter("datum_split_total", "Datum after stages ran and large Values arrays were split.", stats.DatumSplit)
+	counter("buckets_total", "Requests datum were first bucketed into.", stats.Buckets)
+	counter("bisections_total", "Requests that were too large and split in half.", stats.Bisections)
+	counter("bytes_uncompressed_total", "Request body bytes before compression.", stats.BytesUncompressed)
+	counter("bytes_compressed_total", "Request body bytes after compression.", stats.BytesCompressed)
+	counter("retries_total", "Requests retried by the AWS SDK.", stats.Retries)
+
+	dropped := prefix + "_dropped_datum_total"
+	fmt.Fprintf(bw, "# HELP %s Datum dropped instead of sent.\n# TYPE %s counter\n", dropped, dropped)
+	for _, reason := range allDropReasons {
+		fmt.Fprintf(bw, "%s{reason=%q} %d\n", dropped, string(reason), stats.Dropped[reason])
+	}
+
+	latency := prefix + "_send_latency_seconds"
+	fmt.Fprintf(bw, "# HELP %s Latency of each request to the client.\n# TYPE %s histogram\n", latency, latency)
+	cumulative := int64(0)
+	for i, count := range stats.SendLatency.Counts {
+		cumulative += count
+		le := "+Inf"
+		if i < len(stats.SendLatency.Bounds) {
+			le = strconv.FormatFloat(stats.SendLatency.Bounds[i].Seconds(), 'g', -1, 64)
+		}
+		fmt.Fprintf(bw, "%s_bucket{le=%q} %d\n", latency, le, cumulative)
+	}
+	fmt.Fprintf(bw, "%s_sum %s\n", latency, strconv.FormatFloat(float64(stats.SendLatency.Sum)/float64(time.Second), 'g', -1, 64))
+	fmt.Fprintf(bw, "%s_count %d\n", latency, stats.SendLatency.Count)
+	return bw.Flush()
+}
@@ -0,0 +1,52 @@
+package cwpagedmetricput
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishExpvar(t *testing.T) {
+	p := &Pager{Client: &memoryCloudWatchClient{}}
+	_, err := p.PutMetricData(guardInput(0, 2))
+	require.NoError(t, err)
+	PublishExpvar("TestPublishExpvar", p)
+	var stats Stats
+	require.NoError(t, json.Unmarshal([]byte(expvar.Get("TestPublishExpvar").String()), &stats))
+	require.Equal(t, int64(2), stats.DatumIn)
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	p := &Pager{Client: &memoryCloudWatchClient{}}
+	_, err := p.PutMetricData(guardInput(0, 2))
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	PrometheusHandler(p).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, 200, rec.Code)
+	require.Contains(t, rec.Header().Get("Content-Type"), "version=0.0.4")
+	body := rec.Body.String()
+	require.Contains(t, body, "cwpagedmetricput_datum_in_total 2\n")
+	require.Contains(t, body, "# TYPE cwpagedmetricput_send_latency_seconds histogram\n")
+	require.Contains(t, body, "cwpagedmetricput_send_latency_seconds_count 1\n")
+	require.Contains(t, body, `cwpagedmetricput_send_latency_seconds_bucket{le="+Inf"} 1`)
+}
+
+func TestWritePrometheus(t *testing.T) {
+	var s pagerStats
+	s.observeLatency(time.Millisecond * 3)
+	s.observeLatency(time.Millisecond * 30)
+	s.drop(DropReasonBudget, 2)
+	var buf strings.Builder
+	require.NoError(t, WritePrometheus(&buf, "test", s.snapshot()))
+	out := buf.String()
+	require.Contains(t, out, `test_dropped_datum_total{reason="budget"} 2`)
+	require.Contains(t, out, `test_send_latency_seconds_bucket{le="0.001"} 0`)
+	require.Contains(t, out, `test_send_latency_seconds_bucket{le="0.005"} 1`)
+	require.Contains(t, out, `test_send_latency_seconds_bucket{le="0.05"} 2`)
+	require.Contains(t, out, "test_send_latency_seconds_sum 0.033\n")
+}
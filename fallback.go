@@ -0,0 +1,98 @@
+package cwpagedmetricput
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// compressionRejectedCodes are the error codes, returned with a 400, that endpoints use when they cannot parse an
+// encoded body.  Query protocol servers that ignore Content-Encoding see a binary body without an Action or parameters.
+var compressionRejectedCodes = map[string]struct{}{
+	"UnsupportedMediaType":   {},
+	"MalformedQueryString":   {},
+	"InvalidQueryParameter":  {},
+	"SerializationException": {},
+	"MissingAction":          {},
+	"InvalidAction":          {},
+	"MissingParameter":       {},
+}
+
+// isCompressionRejected returns true if err looks like an endpoint failing to understand an encoded body
+func isCompressionRejected(err error) bool {
+	reqErr, ok := err.(awserr.RequestFailure)
+	if !ok {
+		return false
+	}
+	switch reqErr.StatusCode() {
+	case http.StatusUnsupportedMediaType:
+		return true
+	case http.StatusBadRequest:
+		_, exists := compressionRejectedCodes[reqErr.Code()]
+		return exists
+	default:
+		return false
+	}
+}
+
+// encoder returns the BodyEncoder requests are sent with.  Once the endpoint rejects encoded bodies, it is always
+// IdentityEncoder.
+func (c *Pager) encoder() BodyEncoder {
+	if atomic.LoadInt32(&c.compressionRejected) != 0 {
+		return IdentityEncoder{}
+	}
+	return c.Config.encoder()
+}
+
+// CompressionRejected returns true once the Pager has found that its endpoint rejects encoded bodies and has switched
+// to sending them uncompressed
+func (c *Pager) CompressionRejected() bool {
+	return atomic.LoadInt32(&c.compressionRejected) != 0
+}
+
+// shouldFallback returns true if a request encoded by enc that failed with err should be retried uncompressed.  enc
+// is the encoder the request was actually sent with, not the Pager's current one, which another bucket may have
+// already switched.  A request sent uncompressed would send the same body again.
+func (c *Pager) shouldFallback(enc BodyEncoder, err error) bool {
+	return c.Config.CompressionFallback && enc.ContentEncoding() != "" && isCompressionRejected(err)
+}
+
+// rejectCompression remembers that the endpoint rejects encoded bodies, so later requests are sent uncompressed
+func (c *Pager) rejectCompression(err error) {
+	if atomic.CompareAndSwapInt32(&c.compressionRejected, 0, 1) {
+		c.Config.logger().Log(LevelWarn, "endpoint rejected compressed body, sending uncompressed", "err", err)
+	}
+}
+
+// CompressionProbeMetricName is the metric ProbeCompression sends
+const CompressionProbeMetricName = "CompressionProbe"
+
+// ProbeCompression sends a single datum, named CompressionProbe with a value of zero, to namespace to find out before
+// any real metrics are sent whether the endpoint accepts encoded bodies.  If the endpoint rejects the encoded request
+// but accepts it uncompressed, every later request is sent uncompressed, even without Config.CompressionFallback.  An
+// error is returned only if the probe could not be sent at all.  The probe is billed like any other custom metric.
+func (c *Pager) ProbeCompression(ctx context.Context, namespace string) error {
+	enc := c.encoder()
+	in := &cloudwatch.PutMetricDataInput{
+		Namespace: aws.String(namespace),
+		MetricData: []*cloudwatch.MetricDatum{
+			{
+				MetricName: aws.String(CompressionProbeMetricName),
+				Value:      aws.Float64(0),
+			},
+		},
+	}
+	_, err := c.Client.PutMetricDataWithContext(ctx, in, encodeBodyOption(enc, putMetricDataKBRequestSizeLimit, nil))
+	if err == nil || enc.ContentEncoding() == "" || !isCompressionRejected(err) {
+		return err
+	}
+	if _, identityErr := c.Client.PutMetricDataWithContext(ctx, in, encodeBodyOption(IdentityEncoder{}, putMetricDataKBRequestSizeLimit, nil)); identityErr != nil {
+		return identityErr
+	}
+	c.rejectCompression(err)
+	return nil
+}
@@ -0,0 +1,160 @@
+package cwpagedmetricput
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+// gzipRejectingClient builds each request like the SDK would and fails requests with a Content-Encoding header
+type gzipRejectingClient struct {
+	rejectErr error
+	// rejectIdentity rejects uncompressed requests too
+	rejectIdentity bool
+	mu             sync.Mutex
+	encodings      []string
+	in             []*cloudwatch.PutMetricDataInput
+}
+
+func (g *gzipRejectingClient) PutMetricDataWithContext(ctx aws.Context, in *cloudwatch.PutMetricDataInput, opts ...request.Option) (*cloudwatch.PutMetricDataOutput, error) {
+	req := reqWithBody(in.GoString())
+	req.ApplyOptions(opts...)
+	req.Handlers.Build.Run(req)
+	if req.Error != nil {
+		return nil, req.Error
+	}
+	encoding := req.HTTPRequest.Header.Get("Content-Encoding")
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.encodings = append(g.encodings, encoding)
+	if encoding != "" || g.rejectIdentity {
+		return nil, g.rejectErr
+	}
+	g.in = append(g.in, in)
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func Test_isCompressionRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "plain", err: errors.New("bad"), want: false},
+		{name: "size", err: &awsRequestSizeError{size: 10}, want: false},
+		{name: "415", err: awserr.NewRequestFailure(awserr.New("Anything", "bad", nil), http.StatusUnsupportedMediaType, "id"), want: true},
+		{name: "400 missing action", err: awserr.NewRequestFailure(awserr.New("MissingAction", "bad", nil), http.StatusBadRequest, "id"), want: true},
+		{name: "400 throttling", err: awserr.NewRequestFailure(awserr.New("Throttling", "slow down", nil), http.StatusBadRequest, "id"), want: false},
+		{name: "403", err: awserr.NewRequestFailure(awserr.New("AccessDenied", "no", nil), http.StatusForbidden, "id"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, isCompressionRejected(tt.err))
+		})
+	}
+}
+
+func TestPager_CompressionFallback(t *testing.T) {
+	rejectErr := awserr.NewRequestFailure(awserr.New("MissingAction", "no action", nil), http.StatusBadRequest, "id")
+	input := func() *cloudwatch.PutMetricDataInput {
+		return &cloudwatch.PutMetricDataInput{
+			Namespace: aws.String("ns"),
+			MetricData: []*cloudwatch.MetricDatum{
+				{MetricName: aws.String("m"), Value: aws.Float64(1)},
+			},
+		}
+	}
+	t.Run("disabled", func(t *testing.T) {
+		client := &gzipRejectingClient{rejectErr: rejectErr}
+		p := &Pager{Client: client}
+		_, err := p.PutMetricData(input())
+		require.Equal(t, rejectErr, err)
+		require.False(t, p.CompressionRejected())
+		require.Empty(t, client.in)
+	})
+	t.Run("enabled", func(t *testing.T) {
+		client := &gzipRejectingClient{rejectErr: rejectErr}
+		p := &Pager{Client: client, Config: Config{CompressionFallback: true}}
+		_, err := p.PutMetricData(input())
+		require.NoError(t, err)
+		require.True(t, p.CompressionRejected())
+		require.Equal(t, []string{"gzip", ""}, client.encodings)
+		// Later calls go straight to uncompressed
+		_, err = p.PutMetricData(input())
+		require.NoError(t, err)
+		require.Equal(t, []string{"gzip", "", ""}, client.encodings)
+		require.Len(t, client.in, 2)
+		require.Equal(t, int64(3), p.Usage().Requests)
+	})
+	t.Run("other errors", func(t *testing.T) {
+		otherErr := awserr.NewRequestFailure(awserr.New("Throttling", "slow down", nil), http.StatusBadRequest, "id")
+		client := &gzipRejectingClient{rejectErr: otherErr}
+		p := &Pager{Client: client, Config: Config{CompressionFallback: true}}
+		_, err := p.PutMetricData(input())
+		require.Equal(t, otherErr, err)
+		require.False(t, p.CompressionRejected())
+		require.Equal(t, []string{"gzip"}, client.encodings)
+	})
+	t.Run("already rejected", func(t *testing.T) {
+		missingErr := awserr.NewRequestFailure(awserr.New("MissingParameter", "no metric data", nil), http.StatusBadRequest, "id")
+		client := &gzipRejectingClient{rejectErr: missingErr, rejectIdentity: true}
+		p := &Pager{Client: client, Config: Config{CompressionFallback: true}, compressionRejected: 1}
+		_, err := p.PutMetricData(input())
+		require.Equal(t, missingErr, err)
+		require.Equal(t, []string{""}, client.encodings)
+	})
+	t.Run("switched by another bucket", func(t *testing.T) {
+		// A bucket sent compressed fails after a parallel bucket already switched the Pager to uncompressed
+		client := &gzipRejectingClient{rejectErr: rejectErr}
+		p := &Pager{Client: client, Config: Config{CompressionFallback: true}, compressionRejected: 1}
+		enc := p.Config.encoder()
+		reqs := []request.Option{encodeBodyOption(enc, putMetricDataKBRequestSizeLimit, nil)}
+		require.NoError(t, p.sendDatumOnce(context.Background(), aws.String("ns"), input().MetricData, enc, reqs))
+		require.Equal(t, []string{"gzip", ""}, client.encodings)
+		require.Len(t, client.in, 1)
+	})
+	t.Run("uncompressed config", func(t *testing.T) {
+		client := &gzipRejectingClient{rejectErr: rejectErr}
+		p := &Pager{Client: client, Config: Config{CompressionFallback: true, DisableCompression: true}}
+		_, err := p.PutMetricData(input())
+		require.NoError(t, err)
+		require.False(t, p.CompressionRejected())
+		require.Equal(t, []string{""}, client.encodings)
+	})
+}
+
+func TestPager_ProbeCompression(t *testing.T) {
+	rejectErr := awserr.NewRequestFailure(awserr.New("UnsupportedMediaType", "no gzip", nil), http.StatusUnsupportedMediaType, "id")
+	t.Run("rejected", func(t *testing.T) {
+		client := &gzipRejectingClient{rejectErr: rejectErr}
+		p := &Pager{Client: client}
+		require.NoError(t, p.ProbeCompression(context.Background(), "probe"))
+		require.True(t, p.CompressionRejected())
+		require.Len(t, client.in, 1)
+		require.Equal(t, CompressionProbeMetricName, *client.in[0].MetricData[0].MetricName)
+	})
+	t.Run("accepted", func(t *testing.T) {
+		client := &gzipRejectingClient{}
+		p := &Pager{Client: client}
+		// A nil rejectErr means the gzip request "succeeds"
+		require.NoError(t, p.ProbeCompression(context.Background(), "probe"))
+		require.False(t, p.CompressionRejected())
+		require.Equal(t, []string{"gzip"}, client.encodings)
+	})
+	t.Run("failed", func(t *testing.T) {
+		otherErr := errors.New("network down")
+		client := &gzipRejectingClient{rejectErr: otherErr}
+		p := &Pager{Client: client}
+		require.Equal(t, otherErr, p.ProbeCompression(context.Background(), "probe"))
+		require.False(t, p.CompressionRejected())
+	})
+}
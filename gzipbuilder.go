@@ -2,10 +2,14 @@ package cwpagedmetricput
 
 import (
 	"bytes"
-	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"sync"
+	"sync/atomic"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
 )
@@ -18,6 +22,10 @@ const (
 	putMetricDataKBRequestSizeLimit = 38 * 1024
 )
 
+// PutMetricDataSizeLimit is the largest encoded request body GzipBody allows, in bytes.  It is a little under the 40KB
+// CloudWatch documents for PutMetricData, since CloudWatch's limit includes headers.
+const PutMetricDataSizeLimit = putMetricDataKBRequestSizeLimit
+
 // awsRequestSizeError is an internal only type that we use to signal up the call stack that a
 // request will be too large for AWS's API.
 type awsRequestSizeError struct {
@@ -43,43 +51,161 @@ type requestSizeError interface {
 
 var _ requestSizeError = &awsRequestSizeError{}
 
+// IsRequestSizeError returns true if err is from GzipBody or EncodeBody finding an encoded body larger than its limit
+func IsRequestSizeError(err error) bool {
+	_, ok := err.(requestSizeError)
+	return ok
+}
+
 // buildPostGZip construct a gzip'd post request.  Put this *after* the regular handler so it can
 // use the built in SDK logic to compress the request body.  Will set an error with method `RequestSizeError`
 // on the request if the compressed body is too large for API_PutMetricData's API
 func buildPostGZip(r *request.Request) {
-	r.HTTPRequest.Header.Set("Content-Encoding", "gzip")
+	encodeBody(r, GzipEncoder{}, putMetricDataKBRequestSizeLimit, nil)
+}
 
-	// Construct a byte buffer and gzip writer
-	var w bytes.Buffer
-	gzipW := gzip.NewWriter(&w)
+// encodeBody is buildPostGZip, but with any BodyEncoder.  It optionally reports the size of the body before and after
+// encoding.  Encoding stops as soon as the encoded body is larger than limit.  Bodies are encoded into a pooled
+// buffer, but the request gets its own copy, since the transport and retries may read the body after the request
+// completes.
+func encodeBody(r *request.Request, enc BodyEncoder, limit int, onEncode func(before int64, after int64)) {
+	if contentEncoding := enc.ContentEncoding(); contentEncoding != "" {
+		r.HTTPRequest.Header.Set("Content-Encoding", contentEncoding)
+	}
 
-	// GZip the body
-	_, err := io.Copy(gzipW, r.GetBody())
+	body := r.GetBody()
+	before, err := aws.SeekerLen(body)
 	if err != nil {
-		r.Error = awserr.New(request.ErrCodeSerialization, "failed encoding gzip", err)
+		r.Error = awserr.New(request.ErrCodeSerialization, "failed finding body length", err)
 		return
 	}
-	err = gzipW.Close()
-	if err != nil {
-		r.Error = awserr.New(request.ErrCodeSerialization, "failed closing gzip writer", err)
-		return
+
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	w := limitWriter{w: buf, limit: int64(limit)}
+	err = enc.Encode(&w, body)
+	if onEncode != nil {
+		onEncode(before, w.written)
 	}
 
 	// Check the size of the request to determine whether the client should further split the request
-	if len(w.Bytes()) > putMetricDataKBRequestSizeLimit {
+	if w.exceeded {
+		bufferPool.Put(buf)
 		r.Error = &awsRequestSizeError{
-			size: len(w.Bytes()),
+			size: int(w.written),
 		}
 		return
 	}
-	r.SetBufferBody(w.Bytes())
+	if err != nil {
+		bufferPool.Put(buf)
+		r.Error = awserr.New(request.ErrCodeSerialization, "failed encoding body", err)
+		return
+	}
+	encoded := make([]byte, buf.Len())
+	copy(encoded, buf.Bytes())
+	bufferPool.Put(buf)
+	r.SetBufferBody(encoded)
+}
+
+// bufferPool holds the buffers encoded bodies are written to.  They are sized for the request size limit.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return bytes.NewBuffer(make([]byte, 0, putMetricDataKBRequestSizeLimit+1))
+	},
+}
+
+// copyBufferPool holds the buffers used to copy bodies into encoders
+var copyBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 32*1024)
+		return &b
+	},
+}
+
+// pooledCopy is io.Copy, but with a pooled copy buffer
+func pooledCopy(w io.Writer, r io.Reader) (int64, error) {
+	b := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(b)
+	return io.CopyBuffer(w, r, *b)
+}
+
+// errSizeLimit is returned by limitWriter once the limit is exceeded
+var errSizeLimit = errors.New("encoded body larger than request size limit")
+
+// limitWriter fails writes once more than limit bytes are written, so encoding an oversized body stops early.  written
+// is the number of bytes written, including the write that exceeded the limit.
+type limitWriter struct {
+	w        io.Writer
+	limit    int64
+	written  int64
+	exceeded bool
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	l.written += int64(len(p))
+	if l.written > l.limit {
+		l.exceeded = true
+		return 0, errSizeLimit
+	}
+	return l.w.Write(p)
 }
 
 var gzipHandler = request.NamedHandler{Name: "cwpagedmetricput.gzip", Fn: buildPostGZip}
 
-// gzipBody attaches a gzip handler to the Build phase of the eventual AWS request
-func gzipBody(req *request.Request) {
+// GzipBody is a request.Option that gzips the body of a PutMetricData request, for use with a plain
+// *cloudwatch.CloudWatch client.  If the compressed body is larger than PutMetricDataSizeLimit, the request fails
+// with an error that IsRequestSizeError returns true for.
+func GzipBody(req *request.Request) {
 	// Protect from double adds
 	req.Handlers.Build.Remove(gzipHandler)
 	req.Handlers.Build.PushBackNamed(gzipHandler)
 }
+
+var _ request.Option = GzipBody
+
+// EncodeBody is GzipBody with any BodyEncoder and size limit, so other AWS Query protocol APIs can reuse the same
+// compression and size check.  A limit of zero or less is unlimited.  It replaces GzipBody, or an earlier EncodeBody,
+// in place if both are added.
+func EncodeBody(enc BodyEncoder, limit int) request.Option {
+	return encodeBodyOption(enc, limit, nil)
+}
+
+// encodeBodyOption is EncodeBody, but reports the size of the body before and after encoding to onEncode
+func encodeBodyOption(enc BodyEncoder, limit int, onEncode func(before int64, after int64)) request.Option {
+	if limit <= 0 {
+		limit = math.MaxInt32
+	}
+	h := request.NamedHandler{Name: gzipHandler.Name, Fn: func(r *request.Request) {
+		encodeBody(r, enc, limit, onEncode)
+	}}
+	return func(req *request.Request) {
+		// Replace an existing encoder where it is, so handlers added after it, like ReportBodySize, still run later
+		req.Handlers.Build.SetBackNamed(h)
+	}
+}
+
+// reportBodySizeCount makes the handler name of each ReportBodySize option unique
+var reportBodySizeCount int64
+
+// ReportBodySize returns a request.Option that calls onSize with the size of the request body once it is built.  Add
+// it after GzipBody or EncodeBody so it reports the encoded size.  Requests that fail to build, including requests
+// that are too large, are not reported.
+func ReportBodySize(onSize func(size int64)) request.Option {
+	h := request.NamedHandler{
+		Name: fmt.Sprintf("cwpagedmetricput.size.%d", atomic.AddInt64(&reportBodySizeCount, 1)),
+		Fn: func(r *request.Request) {
+			if r.Error != nil {
+				return
+			}
+			size, err := aws.SeekerLen(r.GetBody())
+			if err == nil {
+				onSize(size)
+			}
+		},
+	}
+	return func(req *request.Request) {
+		// Protect from double adds of the same option, while allowing many different ones
+		req.Handlers.Build.Remove(h)
+		req.Handlers.Build.PushBackNamed(h)
+	}
+}
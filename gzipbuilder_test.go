@@ -1,6 +1,11 @@
 package cwpagedmetricput
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"strings"
@@ -72,6 +77,178 @@ func randomString(n int) string {
 
 func TestGzipBody(t *testing.T) {
 	r := reqWithBody("hi")
-	gzipBody(r)
+	GzipBody(r)
 	require.Equal(t, 1, r.Handlers.Build.Len())
 }
+
+func TestReportBodySize(t *testing.T) {
+	r := reqWithBody("hello world")
+	var size int64
+	GzipBody(r)
+	ReportBodySize(func(s int64) {
+		size = s
+	})(r)
+	r.Handlers.Build.Run(r)
+	require.NoError(t, r.Error)
+	require.True(t, size > 0)
+	require.NotEqual(t, int64(len("hello world")), size)
+}
+
+func Test_encodeBodyOption(t *testing.T) {
+	r := reqWithBody("hello world")
+	var before, after int64
+	encodeBodyOption(GzipEncoder{}, 0, func(u int64, c int64) {
+		before, after = u, c
+	})(r)
+	GzipBody(r)
+	require.Equal(t, 1, r.Handlers.Build.Len())
+	r = reqWithBody("hello world")
+	encodeBodyOption(GzipEncoder{}, 0, func(u int64, c int64) {
+		before, after = u, c
+	})(r)
+	r.Handlers.Build.Run(r)
+	require.NoError(t, r.Error)
+	require.Equal(t, int64(len("hello world")), before)
+	require.True(t, after > 0)
+}
+
+// unpooledEncodeBody is how bodies were encoded before writers and buffers were pooled.  It is kept to benchmark
+// against.
+func unpooledEncodeBody(r *request.Request) {
+	r.HTTPRequest.Header.Set("Content-Encoding", "gzip")
+	var w bytes.Buffer
+	gzipW := gzip.NewWriter(&w)
+	if _, err := io.Copy(gzipW, r.GetBody()); err != nil {
+		r.Error = err
+		return
+	}
+	if err := gzipW.Close(); err != nil {
+		r.Error = err
+		return
+	}
+	if len(w.Bytes()) > putMetricDataKBRequestSizeLimit {
+		r.Error = &awsRequestSizeError{size: len(w.Bytes())}
+		return
+	}
+	r.SetBufferBody(w.Bytes())
+}
+
+func benchmarkEncode(b *testing.B, body string, encode func(r *request.Request)) {
+	reqs := make([]*request.Request, b.N)
+	for i := range reqs {
+		reqs[i] = reqWithBody(body)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for _, r := range reqs {
+		encode(r)
+		r.Handlers.Complete.Run(r)
+	}
+}
+
+// A body that compresses about as well as a real PutMetricData body
+var benchBody = strings.Repeat("MetricData.member.1.MetricName=latency&MetricData.member.1.Value=12.5&", 400)
+
+func BenchmarkEncodeBody(b *testing.B) {
+	b.Run("pooled", func(b *testing.B) {
+		benchmarkEncode(b, benchBody, buildPostGZip)
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		benchmarkEncode(b, benchBody, unpooledEncodeBody)
+	})
+}
+
+func BenchmarkEncodeBodyTooLarge(b *testing.B) {
+	body := randomString(1024 * 128)
+	b.Run("pooled", func(b *testing.B) {
+		benchmarkEncode(b, body, buildPostGZip)
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		benchmarkEncode(b, body, unpooledEncodeBody)
+	})
+}
+
+func Test_limitWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := limitWriter{w: &buf, limit: 5}
+	n, err := w.Write([]byte("abc"))
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	_, err = w.Write([]byte("abc"))
+	require.Equal(t, errSizeLimit, err)
+	require.True(t, w.exceeded)
+	require.Equal(t, int64(6), w.written)
+	require.Equal(t, "abc", buf.String())
+}
+
+func TestBuildPostGZip_reusesBuffers(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		r := reqWithBody(benchBody)
+		buildPostGZip(r)
+		require.NoError(t, r.Error)
+		zr, err := gzip.NewReader(r.GetBody())
+		require.NoError(t, err)
+		out, err := ioutil.ReadAll(zr)
+		require.NoError(t, err)
+		require.Equal(t, benchBody, string(out))
+		r.Handlers.Complete.Run(r)
+	}
+}
+
+func Test_encodeBodyOption_replacesInPlace(t *testing.T) {
+	r := reqWithBody("hello world")
+	var size int64
+	r.ApplyOptions(GzipBody, ReportBodySize(func(s int64) {
+		size = s
+	}), EncodeBody(IdentityEncoder{}, 0))
+	require.Equal(t, 2, r.Handlers.Build.Len())
+	r.Handlers.Build.Run(r)
+	require.NoError(t, r.Error)
+	require.Equal(t, "", r.HTTPRequest.Header.Get("Content-Encoding"))
+	require.Equal(t, int64(len("hello world")), size)
+}
+
+func TestBuildPostGZip_bodyOutlivesBuffer(t *testing.T) {
+	first := reqWithBody(benchBody)
+	buildPostGZip(first)
+	require.NoError(t, first.Error)
+	// Encoding another body reuses the pooled buffer, which must not change the first body
+	second := reqWithBody(strings.Repeat("B", len(benchBody)))
+	buildPostGZip(second)
+	require.NoError(t, second.Error)
+	zr, err := gzip.NewReader(first.GetBody())
+	require.NoError(t, err)
+	out, err := ioutil.ReadAll(zr)
+	require.NoError(t, err)
+	require.Equal(t, benchBody, string(out))
+}
+
+func TestReportBodySize_many(t *testing.T) {
+	r := reqWithBody("hello world")
+	var first, second int64
+	opt := ReportBodySize(func(s int64) {
+		first += s
+	})
+	opt(r)
+	opt(r)
+	ReportBodySize(func(s int64) {
+		second = s
+	})(r)
+	require.Equal(t, 2, r.Handlers.Build.Len())
+	r.Handlers.Build.Run(r)
+	require.Equal(t, int64(len("hello world")), first)
+	require.Equal(t, first, second)
+}
+
+func TestEncodeBody(t *testing.T) {
+	r := reqWithBody(strings.Repeat("A", 1024))
+	EncodeBody(IdentityEncoder{}, 100)(r)
+	r.Handlers.Build.Run(r)
+	require.True(t, IsRequestSizeError(r.Error))
+
+	r = reqWithBody(strings.Repeat("A", 1024*1024))
+	EncodeBody(IdentityEncoder{}, 0)(r)
+	r.Handlers.Build.Run(r)
+	require.NoError(t, r.Error)
+	require.False(t, IsRequestSizeError(errors.New("other")))
+}
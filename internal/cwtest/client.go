@@ -0,0 +1,60 @@
+// Package cwtest has test helpers shared by the statsd, lineproto, promscrape, and otlp packages
+package cwtest
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// Client is a CloudWatchClient that records every input it publishes
+type Client struct {
+	mu  sync.Mutex
+	in  []*cloudwatch.PutMetricDataInput
+	err error
+}
+
+// PutMetricDataWithContext records in, unless the client is failing
+func (c *Client) PutMetricDataWithContext(_ aws.Context, in *cloudwatch.PutMetricDataInput, _ ...request.Option) (*cloudwatch.PutMetricDataOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.in = append(c.in, in)
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+// SetErr makes every later call fail with err, without being recorded, until it is called again with nil
+func (c *Client) SetErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+// Inputs returns every published input, in order
+func (c *Client) Inputs() []*cloudwatch.PutMetricDataInput {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*cloudwatch.PutMetricDataInput(nil), c.in...)
+}
+
+// Datum returns the datum of every published input, in order
+func (c *Client) Datum() []*cloudwatch.MetricDatum {
+	var ret []*cloudwatch.MetricDatum
+	for _, in := range c.Inputs() {
+		ret = append(ret, in.MetricData...)
+	}
+	return ret
+}
+
+// Names returns the metric name of every published datum, in order
+func (c *Client) Names() []string {
+	var ret []string
+	for _, d := range c.Datum() {
+		ret = append(ret, aws.StringValue(d.MetricName))
+	}
+	return ret
+}
@@ -0,0 +1,36 @@
+package cwtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	input := func(names ...string) *cloudwatch.PutMetricDataInput {
+		ret := &cloudwatch.PutMetricDataInput{Namespace: aws.String("ns")}
+		for _, name := range names {
+			ret.MetricData = append(ret.MetricData, &cloudwatch.MetricDatum{MetricName: aws.String(name)})
+		}
+		return ret
+	}
+	c := &Client{}
+	_, err := c.PutMetricDataWithContext(context.Background(), input("a", "b"))
+	require.NoError(t, err)
+
+	unavailable := errors.New("unavailable")
+	c.SetErr(unavailable)
+	_, err = c.PutMetricDataWithContext(context.Background(), input("dropped"))
+	require.Equal(t, unavailable, err)
+	c.SetErr(nil)
+
+	_, err = c.PutMetricDataWithContext(context.Background(), input("c"))
+	require.NoError(t, err)
+	require.Len(t, c.Inputs(), 2)
+	require.Len(t, c.Datum(), 3)
+	require.Equal(t, []string{"a", "b", "c"}, c.Names())
+}
@@ -0,0 +1,24 @@
+// Package defaults has the defaults shared by the statsd, lineproto, promscrape, and otlp packages
+package defaults
+
+import (
+	"time"
+
+	"github.com/cep21/cwpagedmetricput"
+)
+
+// Logger returns logger, or cwpagedmetricput.NopLogger if it is nil
+func Logger(logger cwpagedmetricput.Logger) cwpagedmetricput.Logger {
+	if logger == nil {
+		return cwpagedmetricput.NopLogger{}
+	}
+	return logger
+}
+
+// Now returns now(), or time.Now() if now is nil.  Packages keep a now field so tests can replace the clock.
+func Now(now func() time.Time) time.Time {
+	if now != nil {
+		return now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,38 @@
+// Package flushloop is the read and flush loop shared by the statsd and lineproto servers
+package flushloop
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Run calls read in the background and flush every interval until ctx ends or read returns.  It then closes closer
+// and flushes once more.  If read failed, Run returns its error, after reporting the last flush's error to onFlushErr.
+// If ctx ended, the last flush uses a new context so what is left is still sent, and Run returns the flush's error.
+func Run(ctx context.Context, interval time.Duration, closer io.Closer, read func() error, flush func(ctx context.Context) error, onFlushErr func(err error)) error {
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- read()
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := flush(ctx); err != nil {
+				onFlushErr(err)
+			}
+		case err := <-readErr:
+			_ = closer.Close()
+			if flushErr := flush(ctx); flushErr != nil {
+				onFlushErr(flushErr)
+			}
+			return err
+		case <-ctx.Done():
+			_ = closer.Close()
+			<-readErr
+			return flush(context.Background())
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package flushloop
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type closer struct {
+	closed chan struct{}
+}
+
+func (c *closer) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	t.Run("read fails", func(t *testing.T) {
+		readErr := errors.New("closed")
+		flushErr := errors.New("unavailable")
+		var reported []error
+		c := &closer{closed: make(chan struct{})}
+		err := Run(context.Background(), time.Hour, c, func() error {
+			return readErr
+		}, func(context.Context) error {
+			return flushErr
+		}, func(err error) {
+			reported = append(reported, err)
+		})
+		require.Equal(t, readErr, err)
+		require.Equal(t, []error{flushErr}, reported)
+	})
+	t.Run("context ends", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		c := &closer{closed: make(chan struct{})}
+		flushes := make(chan context.Context, 100)
+		go func() {
+			// Wait for a flush from the ticker before ending
+			<-flushes
+			cancel()
+		}()
+		err := Run(ctx, time.Millisecond, c, func() error {
+			<-c.closed
+			return errors.New("closed")
+		}, func(ctx context.Context) error {
+			flushes <- ctx
+			return nil
+		}, func(error) {})
+		require.NoError(t, err)
+		// The last flush still has time to send
+		var last context.Context
+		for len(flushes) > 0 {
+			last = <-flushes
+		}
+		require.NoError(t, last.Err())
+	})
+}
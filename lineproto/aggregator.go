@@ -0,0 +1,92 @@
+package lineproto
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// series is every value of one metric name, dimension set, and second received since the last flush
+type series struct {
+	// first is the first datum of the series, and holds its name, dimensions, and timestamp
+	first *cloudwatch.MetricDatum
+	// values are in the order they were first seen
+	values []float64
+	counts map[float64]float64
+}
+
+// aggregator merges datum of the same series between flushes
+type aggregator struct {
+	mu     sync.Mutex
+	series map[string]*series
+	order  []*series
+}
+
+// seriesKey is the same for datum that merge together.  CloudWatch stores at most one second resolution, so values
+// within the same second merge.
+func seriesKey(d *cloudwatch.MetricDatum) string {
+	parts := make([]string, 0, len(d.Dimensions))
+	for _, dim := range d.Dimensions {
+		if dim != nil {
+			parts = append(parts, aws.StringValue(dim.Name)+"="+aws.StringValue(dim.Value))
+		}
+	}
+	sort.Strings(parts)
+	return aws.StringValue(d.MetricName) + "\x00" + strings.Join(parts, "\x00") + "\x00" + strconv.FormatInt(aws.TimeValue(d.Timestamp).Unix(), 10)
+}
+
+// add merges d into its series.  It returns false, and drops d, if d would start a series past maxSeries.
+func (a *aggregator) add(d *cloudwatch.MetricDatum, maxSeries int) bool {
+	key := seriesKey(d)
+	value := aws.Float64Value(d.Value)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.series == nil {
+		a.series = make(map[string]*series)
+	}
+	s, exists := a.series[key]
+	if !exists {
+		if len(a.order) >= maxSeries {
+			return false
+		}
+		s = &series{first: d, counts: make(map[float64]float64)}
+		a.series[key] = s
+		a.order = append(a.order, s)
+	}
+	if _, seen := s.counts[value]; !seen {
+		s.values = append(s.values, value)
+	}
+	s.counts[value]++
+	return true
+}
+
+// flush returns a datum for every series and forgets them.  A series with a single value is sent as Value, and
+// others as Values and Counts.
+func (a *aggregator) flush() []*cloudwatch.MetricDatum {
+	a.mu.Lock()
+	order := a.order
+	a.series = nil
+	a.order = nil
+	a.mu.Unlock()
+	ret := make([]*cloudwatch.MetricDatum, 0, len(order))
+	for _, s := range order {
+		if len(s.values) == 1 && s.counts[s.values[0]] == 1 {
+			ret = append(ret, s.first)
+			continue
+		}
+		d := *s.first
+		d.Value = nil
+		d.Values = make([]*float64, 0, len(s.values))
+		d.Counts = make([]*float64, 0, len(s.values))
+		for _, v := range s.values {
+			d.Values = append(d.Values, aws.Float64(v))
+			d.Counts = append(d.Counts, aws.Float64(s.counts[v]))
+		}
+		ret = append(ret, &d)
+	}
+	return ret
+}
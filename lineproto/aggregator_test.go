@@ -0,0 +1,39 @@
+package lineproto
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_aggregator(t *testing.T) {
+	now := time.Unix(1000, 0)
+	datum := func(name string, value float64, at time.Time, dims ...*cloudwatch.Dimension) *cloudwatch.MetricDatum {
+		return &cloudwatch.MetricDatum{MetricName: aws.String(name), Dimensions: dims, Value: aws.Float64(value), Timestamp: aws.Time(at)}
+	}
+	var a aggregator
+	for _, d := range []*cloudwatch.MetricDatum{
+		datum("latency", 5, now, dim("host", "a"), dim("route", "home")),
+		datum("latency", 3, now.Add(time.Millisecond*500), dim("route", "home"), dim("host", "a")),
+		datum("latency", 5, now),
+		datum("latency", 5, now, dim("host", "a"), dim("route", "home")),
+		datum("latency", 1, now.Add(time.Second), dim("host", "a"), dim("route", "home")),
+		datum("requests", 1, now),
+	} {
+		require.True(t, a.add(d, 4))
+	}
+	require.False(t, a.add(datum("errors", 1, now), 4))
+	got := a.flush()
+	require.Len(t, got, 4)
+	require.Equal(t, []*float64{aws.Float64(5), aws.Float64(3)}, got[0].Values)
+	require.Equal(t, []*float64{aws.Float64(2), aws.Float64(1)}, got[0].Counts)
+	require.Nil(t, got[0].Value)
+	require.Equal(t, now, *got[0].Timestamp)
+	require.Equal(t, 5.0, *got[1].Value)
+	require.Equal(t, 1.0, *got[2].Value)
+	require.Equal(t, "requests", *got[3].MetricName)
+	require.Empty(t, a.flush())
+}
@@ -0,0 +1,135 @@
+package lineproto
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// GraphiteTemplate maps the parts of a dotted Graphite path to a metric name and dimensions
+type GraphiteTemplate struct {
+	// Filter selects the paths the template applies to, one pattern per part, like "servers.*.cpu".  Paths may have
+	// more parts than the filter.  Empty matches every path.
+	Filter string
+	// Template names each part of the path.  "measurement" parts are joined with dots into the metric name,
+	// "measurement*" also takes every part after it, empty parts are dropped, and any other word makes the part a
+	// dimension of that name.  For example ".host.measurement*" turns "servers.web1.cpu.idle" into the metric
+	// "cpu.idle" with the dimension host=web1.
+	Template string
+}
+
+// matches returns true if the filter matches the start of parts
+func (t *GraphiteTemplate) matches(parts []string) bool {
+	if t.Filter == "" {
+		return true
+	}
+	filter := strings.Split(t.Filter, ".")
+	if len(filter) > len(parts) {
+		return false
+	}
+	for i, pattern := range filter {
+		if matched, err := path.Match(pattern, parts[i]); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// apply returns the metric name and dimensions of parts.  Parts that map to the same dimension are joined with dots.
+func (t *GraphiteTemplate) apply(parts []string) (string, []*cloudwatch.Dimension, error) {
+	var name []string
+	var dimensions []*cloudwatch.Dimension
+	byName := make(map[string]*cloudwatch.Dimension)
+	for i, word := range strings.Split(t.Template, ".") {
+		if i >= len(parts) {
+			break
+		}
+		switch word {
+		case "":
+		case "measurement":
+			name = append(name, parts[i])
+		case "measurement*":
+			name = append(name, parts[i:]...)
+		default:
+			if d, exists := byName[word]; exists {
+				d.Value = aws.String(*d.Value + "." + parts[i])
+				continue
+			}
+			d := &cloudwatch.Dimension{Name: aws.String(word), Value: aws.String(parts[i])}
+			byName[word] = d
+			dimensions = append(dimensions, d)
+		}
+		if word == "measurement*" {
+			break
+		}
+	}
+	if len(name) == 0 {
+		return "", nil, fmt.Errorf("lineproto: template %q has no measurement", t.Template)
+	}
+	return strings.Join(name, "."), dimensions, nil
+}
+
+// GraphiteParser parses Graphite plaintext lines, like "servers.web1.cpu.idle 42 1565000000".  Graphite tags, like
+// "cpu.idle;host=web1 42", become dimensions.
+type GraphiteParser struct {
+	// Templates are tried in order, and the first whose Filter matches is used.  A path no template matches becomes
+	// the metric name as is.
+	Templates []GraphiteTemplate
+}
+
+var _ Parser = &GraphiteParser{}
+
+// Parse returns the datum of a single line.  Lines without a timestamp, or with -1, use now.
+func (p *GraphiteParser) Parse(line []byte, now time.Time) ([]*cloudwatch.MetricDatum, error) {
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, fmt.Errorf("lineproto: graphite line needs a path, value, and optional timestamp")
+	}
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("lineproto: invalid graphite value %q", fields[1])
+	}
+	timestamp := now
+	if len(fields) == 3 && fields[2] != "-1" {
+		seconds, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("lineproto: invalid graphite timestamp %q", fields[2])
+		}
+		timestamp = time.Unix(0, int64(seconds*float64(time.Second)))
+	}
+
+	tagged := strings.Split(fields[0], ";")
+	parts := strings.Split(tagged[0], ".")
+	name := tagged[0]
+	var dimensions []*cloudwatch.Dimension
+	for i := range p.Templates {
+		if p.Templates[i].matches(parts) {
+			if name, dimensions, err = p.Templates[i].apply(parts); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	for _, tag := range tagged[1:] {
+		eq := strings.IndexByte(tag, '=')
+		if eq <= 0 || eq == len(tag)-1 {
+			return nil, fmt.Errorf("lineproto: invalid graphite tag %q", tag)
+		}
+		dimensions = append(dimensions, &cloudwatch.Dimension{
+			Name:  aws.String(tag[:eq]),
+			Value: aws.String(tag[eq+1:]),
+		})
+	}
+	return []*cloudwatch.MetricDatum{{
+		MetricName: aws.String(name),
+		Dimensions: dimensions,
+		Value:      aws.Float64(value),
+		Timestamp:  aws.Time(timestamp),
+	}}, nil
+}
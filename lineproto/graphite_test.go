@@ -0,0 +1,74 @@
+package lineproto
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+func dim(name string, value string) *cloudwatch.Dimension {
+	return &cloudwatch.Dimension{Name: aws.String(name), Value: aws.String(value)}
+}
+
+func TestGraphiteParser_Parse(t *testing.T) {
+	now := time.Unix(1000, 0)
+	p := &GraphiteParser{
+		Templates: []GraphiteTemplate{
+			{Filter: "servers.*.disk", Template: ".host.measurement.device.device"},
+			{Filter: "servers", Template: ".host.measurement*"},
+			{Filter: "broken", Template: "host"},
+		},
+	}
+	tests := []struct {
+		line    string
+		want    *cloudwatch.MetricDatum
+		wantErr bool
+	}{
+		{
+			line: "servers.web1.cpu.idle 42 1565000000",
+			want: &cloudwatch.MetricDatum{
+				MetricName: aws.String("cpu.idle"),
+				Dimensions: []*cloudwatch.Dimension{dim("host", "web1")},
+				Value:      aws.Float64(42),
+				Timestamp:  aws.Time(time.Unix(1565000000, 0)),
+			},
+		},
+		{
+			line: "servers.web1.disk.sda.1 7",
+			want: &cloudwatch.MetricDatum{
+				MetricName: aws.String("disk"),
+				Dimensions: []*cloudwatch.Dimension{dim("host", "web1"), dim("device", "sda.1")},
+				Value:      aws.Float64(7),
+				Timestamp:  aws.Time(now),
+			},
+		},
+		{
+			line: "other.metric;env=prod 1.5 -1",
+			want: &cloudwatch.MetricDatum{
+				MetricName: aws.String("other.metric"),
+				Dimensions: []*cloudwatch.Dimension{dim("env", "prod")},
+				Value:      aws.Float64(1.5),
+				Timestamp:  aws.Time(now),
+			},
+		},
+		{line: "no.value", wantErr: true},
+		{line: "bad.value abc", wantErr: true},
+		{line: "bad.timestamp 1 abc", wantErr: true},
+		{line: "bad.tag;env 1", wantErr: true},
+		{line: "broken.a 1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := p.Parse([]byte(tt.line), now)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, []*cloudwatch.MetricDatum{tt.want}, got)
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package lineproto
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+const defaultInfluxSeparator = "_"
+
+// InfluxParser parses InfluxDB line protocol, like "cpu,host=web1 idle=42,user=3i 1565000000000000000".  Tags become
+// dimensions, and each numeric or boolean field becomes a metric named after the measurement and field.  A field
+// called "value" is named after the measurement alone.  String fields are ignored.
+type InfluxParser struct {
+	// Precision of timestamps.  Defaults to nanoseconds.
+	Precision time.Duration
+	// Separator joins the measurement and field into the metric name.  Defaults to "_".
+	Separator string
+}
+
+var _ Parser = &InfluxParser{}
+
+func (p *InfluxParser) precision() time.Duration {
+	if p.Precision <= 0 {
+		return time.Nanosecond
+	}
+	return p.Precision
+}
+
+func (p *InfluxParser) separator() string {
+	if p.Separator == "" {
+		return defaultInfluxSeparator
+	}
+	return p.Separator
+}
+
+// Parse returns a datum for each numeric field of a single line.  Lines without a timestamp use now.
+func (p *InfluxParser) Parse(line []byte, now time.Time) ([]*cloudwatch.MetricDatum, error) {
+	var sections []string
+	for _, s := range splitUnescaped(string(line), ' ', true) {
+		if s != "" {
+			sections = append(sections, s)
+		}
+	}
+	if len(sections) < 2 || len(sections) > 3 {
+		return nil, fmt.Errorf("lineproto: influx line needs a measurement, fields, and optional timestamp")
+	}
+	timestamp := now
+	if len(sections) == 3 {
+		ts, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("lineproto: invalid influx timestamp %q", sections[2])
+		}
+		timestamp = time.Unix(0, ts*int64(p.precision()))
+	}
+
+	series := splitUnescaped(sections[0], ',', false)
+	measurement := unescape(series[0])
+	if measurement == "" {
+		return nil, fmt.Errorf("lineproto: influx line has no measurement")
+	}
+	dimensions := make([]*cloudwatch.Dimension, 0, len(series)-1)
+	for _, tag := range series[1:] {
+		key, value, err := splitPair(tag, false)
+		if err != nil {
+			return nil, err
+		}
+		dimensions = append(dimensions, &cloudwatch.Dimension{
+			Name:  aws.String(key),
+			Value: aws.String(value),
+		})
+	}
+
+	var ret []*cloudwatch.MetricDatum
+	for _, field := range splitUnescaped(sections[1], ',', true) {
+		key, raw, err := splitPair(field, true)
+		if err != nil {
+			return nil, err
+		}
+		value, numeric, err := influxFieldValue(raw)
+		if err != nil {
+			return nil, err
+		}
+		if !numeric {
+			continue
+		}
+		name := measurement
+		if key != "value" {
+			name = measurement + p.separator() + key
+		}
+		ret = append(ret, &cloudwatch.MetricDatum{
+			MetricName: aws.String(name),
+			Dimensions: dimensions,
+			Value:      aws.Float64(value),
+			Timestamp:  aws.Time(timestamp),
+		})
+	}
+	return ret, nil
+}
+
+// influxFieldValue parses a field value, returning false for strings
+func influxFieldValue(raw string) (float64, bool, error) {
+	if strings.HasPrefix(raw, `"`) {
+		return 0, false, nil
+	}
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+	var value float64
+	var err error
+	switch {
+	case strings.HasSuffix(raw, "i"):
+		var i int64
+		i, err = strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		value = float64(i)
+	case strings.HasSuffix(raw, "u"):
+		var u uint64
+		u, err = strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+		value = float64(u)
+	default:
+		value, err = strconv.ParseFloat(raw, 64)
+	}
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, false, fmt.Errorf("lineproto: invalid influx field value %q", raw)
+	}
+	return value, true, nil
+}
+
+// splitPair splits key=value at the first unescaped equals sign and unescapes the key.  Tag values are unescaped too,
+// but field values are returned as written so their type can be read.
+func splitPair(s string, field bool) (string, string, error) {
+	parts := splitUnescaped(s, '=', field)
+	if len(parts) < 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("lineproto: invalid influx key value %q", s)
+	}
+	value := s[len(parts[0])+1:]
+	if !field {
+		value = unescape(value)
+	}
+	if value == "" {
+		return "", "", fmt.Errorf("lineproto: influx key %s has no value", parts[0])
+	}
+	return unescape(parts[0]), value, nil
+}
+
+// splitUnescaped splits s at each sep that is not escaped with a backslash, and optionally not inside double quotes
+func splitUnescaped(s string, sep byte, quotes bool) []string {
+	var ret []string
+	inQuote := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quotes && s[i] == '"':
+			inQuote = !inQuote
+		case s[i] == sep && !inQuote:
+			ret = append(ret, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(ret, s[start:])
+}
+
+// unescape removes the backslash from escaped commas, spaces, equals signs, and backslashes
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`, =\`, s[i+1]) != -1 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
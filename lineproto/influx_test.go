@@ -0,0 +1,83 @@
+package lineproto
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxParser_Parse(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tests := []struct {
+		line    string
+		parser  InfluxParser
+		want    []*cloudwatch.MetricDatum
+		wantErr bool
+	}{
+		{
+			line: "cpu,host=web1,region=us\\ west idle=42,user=3i,up=t,name=\"a b,c\" 1565000000000000000",
+			want: []*cloudwatch.MetricDatum{
+				{
+					MetricName: aws.String("cpu_idle"),
+					Dimensions: []*cloudwatch.Dimension{dim("host", "web1"), dim("region", "us west")},
+					Value:      aws.Float64(42),
+					Timestamp:  aws.Time(time.Unix(1565000000, 0)),
+				},
+				{
+					MetricName: aws.String("cpu_user"),
+					Dimensions: []*cloudwatch.Dimension{dim("host", "web1"), dim("region", "us west")},
+					Value:      aws.Float64(3),
+					Timestamp:  aws.Time(time.Unix(1565000000, 0)),
+				},
+				{
+					MetricName: aws.String("cpu_up"),
+					Dimensions: []*cloudwatch.Dimension{dim("host", "web1"), dim("region", "us west")},
+					Value:      aws.Float64(1),
+					Timestamp:  aws.Time(time.Unix(1565000000, 0)),
+				},
+			},
+		},
+		{
+			line:   "requests value=5u 1565000000",
+			parser: InfluxParser{Precision: time.Second, Separator: "."},
+			want: []*cloudwatch.MetricDatum{
+				{
+					MetricName: aws.String("requests"),
+					Dimensions: []*cloudwatch.Dimension{},
+					Value:      aws.Float64(5),
+					Timestamp:  aws.Time(time.Unix(1565000000, 0)),
+				},
+			},
+		},
+		{
+			line: "my\\,measure ok=1.5",
+			want: []*cloudwatch.MetricDatum{
+				{
+					MetricName: aws.String("my,measure_ok"),
+					Dimensions: []*cloudwatch.Dimension{},
+					Value:      aws.Float64(1.5),
+					Timestamp:  aws.Time(now),
+				},
+			},
+		},
+		{line: "nofields", wantErr: true},
+		{line: "cpu idle=abc", wantErr: true},
+		{line: "cpu idle=1 notatime", wantErr: true},
+		{line: "cpu,host idle=1", wantErr: true},
+		{line: "cpu idle=", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := tt.parser.Parse([]byte(tt.line), now)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
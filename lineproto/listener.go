@@ -0,0 +1,232 @@
+/*
+Package lineproto parses Graphite plaintext and InfluxDB line protocol, and listens for them over TCP or UDP to
+publish to CloudWatch through a cwpagedmetricput.Pager.
+*/
+package lineproto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/cep21/cwpagedmetricput"
+	"github.com/cep21/cwpagedmetricput/internal/defaults"
+	"github.com/cep21/cwpagedmetricput/internal/flushloop"
+)
+
+const (
+	defaultFlushInterval = 10 * time.Second
+	defaultMaxSeries     = 100000
+	// maxLineSize is the longest TCP line read
+	maxLineSize = 1024 * 1024
+	// maxPacketSize is the largest UDP payload
+	maxPacketSize = 65535
+)
+
+// Parser turns a single line into datum
+type Parser interface {
+	Parse(line []byte, now time.Time) ([]*cloudwatch.MetricDatum, error)
+}
+
+// Listener reads lines over TCP or UDP, parses them with Parser, and publishes the datum every FlushInterval.  Values of
+// the same metric, dimensions, and second are merged into Values and Counts.
+type Listener struct {
+	// Client receives the metrics.  It is required and is usually a *cwpagedmetricput.Pager.
+	Client cwpagedmetricput.CloudWatchClient
+	// Namespace of every metric.  It is required.
+	Namespace string
+	// Parser is required.  Usually a *GraphiteParser or *InfluxParser.
+	Parser Parser
+	// FlushInterval is how often datum are published.  Defaults to 10 seconds.
+	FlushInterval time.Duration
+	// OnError, if set, is called with each line that cannot be parsed.  The line is dropped either way.
+	OnError func(line string, err error)
+	// MaxSeries limits how many distinct series, each a metric, dimension set, and second, are held between flushes.
+	// Datum that would start a series past it are dropped.  Defaults to 100000.
+	MaxSeries int
+	// OnDropped, if set, is called with each datum dropped because of MaxSeries
+	OnDropped func(datum *cloudwatch.MetricDatum)
+	// Logger receives publish and connection errors.  Defaults to cwpagedmetricput.NopLogger.
+	Logger cwpagedmetricput.Logger
+
+	agg aggregator
+	// now is time.Now, but can be replaced for testing
+	now func() time.Time
+}
+
+func (l *Listener) flushInterval() time.Duration {
+	if l.FlushInterval <= 0 {
+		return defaultFlushInterval
+	}
+	return l.FlushInterval
+}
+
+func (l *Listener) maxSeries() int {
+	if l.MaxSeries <= 0 {
+		return defaultMaxSeries
+	}
+	return l.MaxSeries
+}
+
+// HandleLine parses a single line and queues its datum.  Empty lines and lines starting with # are ignored.  Use it
+// to feed lines from a transport other than TCP or UDP.
+func (l *Listener) HandleLine(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] == '#' {
+		return
+	}
+	datum, err := l.Parser.Parse(line, defaults.Now(l.now))
+	if err != nil {
+		if l.OnError != nil {
+			l.OnError(string(line), err)
+		}
+		return
+	}
+	for _, d := range datum {
+		if !l.agg.add(d, l.maxSeries()) && l.OnDropped != nil {
+			l.OnDropped(d)
+		}
+	}
+}
+
+// Flush publishes every queued datum
+func (l *Listener) Flush(ctx context.Context) error {
+	datum := l.agg.flush()
+	if len(datum) == 0 {
+		return nil
+	}
+	_, err := l.Client.PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String(l.Namespace),
+		MetricData: datum,
+	})
+	return err
+}
+
+// ListenAndServe listens on network, which is tcp or udp, and calls ServeTCP or ServeUDP
+func (l *Listener) ListenAndServe(ctx context.Context, network string, addr string) error {
+	switch network {
+	case "udp", "udp4", "udp6":
+		conn, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return err
+		}
+		return l.ServeUDP(ctx, conn)
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	return l.ServeTCP(ctx, ln)
+}
+
+// ServeUDP reads newline separated lines from each packet of conn until ctx ends or reading fails.  It closes conn,
+// then publishes what is left, returning the error of that last flush.
+func (l *Listener) ServeUDP(ctx context.Context, conn net.PacketConn) error {
+	return l.serve(ctx, conn, func() error {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+				l.HandleLine(line)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// ServeTCP reads lines from each connection of ln until ctx ends or accepting fails.  It closes ln and every
+// connection, then publishes what is left, returning the error of that last flush.
+func (l *Listener) ServeTCP(ctx context.Context, ln net.Listener) error {
+	conns := &connSet{conns: make(map[net.Conn]struct{})}
+	return l.serve(ctx, closerFunc(func() error {
+		err := ln.Close()
+		conns.closeAll()
+		return err
+	}), func() error {
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				conns.closeAll()
+				return err
+			}
+			if !conns.add(conn) {
+				_ = conn.Close()
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conns.remove(conn)
+				l.readLines(conn)
+			}()
+		}
+	})
+}
+
+func (l *Listener) readLines(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		l.HandleLine(scanner.Bytes())
+	}
+	if err := scanner.Err(); err != nil {
+		defaults.Logger(l.Logger).Log(cwpagedmetricput.LevelDebug, "closing line protocol connection", "remote_addr", conn.RemoteAddr().String(), "err", err)
+	}
+}
+
+// serve runs read in the background and flushes every FlushInterval until ctx ends or read returns
+func (l *Listener) serve(ctx context.Context, closer io.Closer, read func() error) error {
+	return flushloop.Run(ctx, l.flushInterval(), closer, read, l.Flush, func(err error) {
+		defaults.Logger(l.Logger).Log(cwpagedmetricput.LevelWarn, "unable to publish line protocol metrics", "err", err)
+	})
+}
+
+type closerFunc func() error
+
+func (c closerFunc) Close() error {
+	return c()
+}
+
+// connSet tracks open TCP connections so they can be closed on shutdown
+type connSet struct {
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// add returns false if the set is already closed
+func (c *connSet) add(conn net.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.conns[conn] = struct{}{}
+	return true
+}
+
+func (c *connSet) remove(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conns, conn)
+	_ = conn.Close()
+}
+
+func (c *connSet) closeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for conn := range c.conns {
+		_ = conn.Close()
+	}
+}
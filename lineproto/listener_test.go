@@ -0,0 +1,113 @@
+package lineproto
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/cep21/cwpagedmetricput/internal/cwtest"
+	"github.com/stretchr/testify/require"
+)
+
+// errorRecorder collects the lines passed to OnError
+type errorRecorder struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (e *errorRecorder) onError(line string, _ error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lines = append(e.lines, line)
+}
+
+func TestListener_HandleLine(t *testing.T) {
+	client := &cwtest.Client{}
+	errs := &errorRecorder{}
+	l := &Listener{Client: client, Namespace: "ns", Parser: &GraphiteParser{}, OnError: errs.onError}
+	for _, line := range []string{"a 1", "", "# comment", "bad", "b 2\r"} {
+		l.HandleLine([]byte(line))
+	}
+	require.Equal(t, []string{"bad"}, errs.lines)
+	require.NoError(t, l.Flush(context.Background()))
+	require.Equal(t, []string{"a", "b"}, client.Names())
+	require.Equal(t, "ns", *client.Inputs()[0].Namespace)
+
+	require.NoError(t, l.Flush(context.Background()))
+	require.Len(t, client.Inputs(), 1)
+}
+
+func TestListener_MaxSeries(t *testing.T) {
+	client := &cwtest.Client{}
+	var dropped []string
+	l := &Listener{Client: client, Namespace: "ns", Parser: &GraphiteParser{}, MaxSeries: 2, OnDropped: func(d *cloudwatch.MetricDatum) {
+		dropped = append(dropped, *d.MetricName)
+	}}
+	for _, line := range []string{"a 1 100", "a 2 100", "b 1 100", "c 1 100", "a 3 100"} {
+		l.HandleLine([]byte(line))
+	}
+	require.Equal(t, []string{"c"}, dropped)
+	require.NoError(t, l.Flush(context.Background()))
+	require.Equal(t, []string{"a", "b"}, client.Names())
+	require.Len(t, client.Inputs()[0].MetricData[0].Values, 3)
+}
+
+func TestListener_ServeTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	client := &cwtest.Client{}
+	errs := &errorRecorder{}
+	l := &Listener{Client: client, Namespace: "ns", Parser: &InfluxParser{}, OnError: errs.onError, FlushInterval: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- l.ServeTCP(ctx, ln)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("cpu idle=1\ncpu user=2\nnot a line\n"))
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		errs.mu.Lock()
+		n := len(errs.lines)
+		errs.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	// Shutting down closes the open connection and sends what is left
+	cancel()
+	require.NoError(t, <-done)
+	require.Equal(t, []string{"cpu_idle", "cpu_user"}, client.Names())
+	require.Equal(t, []string{"not a line"}, errs.lines)
+	require.NoError(t, conn.Close())
+}
+
+func TestListener_ServeUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	client := &cwtest.Client{}
+	l := &Listener{Client: client, Namespace: "ns", Parser: &GraphiteParser{}, FlushInterval: time.Millisecond * 10}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- l.ServeUDP(ctx, conn)
+	}()
+
+	sender, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	_, err = sender.Write([]byte("a.b 1\na.c 2"))
+	require.NoError(t, err)
+	for i := 0; i < 100 && len(client.Names()) < 2; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	cancel()
+	require.NoError(t, <-done)
+	require.Equal(t, []string{"a.b", "a.c"}, client.Names())
+	require.NoError(t, sender.Close())
+}
@@ -0,0 +1,97 @@
+package cwpagedmetricput
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// LogLevel is the severity of a logged event
+type LogLevel int
+
+const (
+	// LevelDebug is for routine events, like splitting a request that was too large
+	LevelDebug LogLevel = iota
+	// LevelInfo is for notable but expected events, like retries
+	LevelInfo
+	// LevelWarn is for events that lose data, like dropped datum
+	LevelWarn
+	// LevelError is for events that should never happen, like a single datum too large to send
+	LevelError
+)
+
+// String returns the lowercase name of the level
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// Logger receives structured diagnostic events from Pager.  keyvals alternate between string keys and values.
+type Logger interface {
+	Log(level LogLevel, msg string, keyvals ...interface{})
+}
+
+// NopLogger is a Logger that discards every event.  It is the default.
+type NopLogger struct{}
+
+var _ Logger = NopLogger{}
+
+// Log does nothing
+func (NopLogger) Log(LogLevel, string, ...interface{}) {}
+
+// StdLogger is a Logger that writes events to a standard library *log.Logger as logfmt style lines
+type StdLogger struct {
+	// Logger is where events are written.  Defaults to log's standard logger.
+	Logger *log.Logger
+	// MinLevel drops events below this level
+	MinLevel LogLevel
+}
+
+var _ Logger = StdLogger{}
+
+// Log formats the event as `level=... msg=... key=value` and prints it
+func (s StdLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	if level < s.MinLevel {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "level=%s msg=%q", level, msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var v interface{} = "(MISSING)"
+		if i+1 < len(keyvals) {
+			v = keyvals[i+1]
+		}
+		fmt.Fprintf(&b, " %v=%s", keyvals[i], logfmtValue(v))
+	}
+	if s.Logger == nil {
+		log.Print(b.String())
+		return
+	}
+	s.Logger.Print(b.String())
+}
+
+// logfmtValue quotes values that would otherwise be ambiguous in a logfmt line
+func logfmtValue(v interface{}) string {
+	str := fmt.Sprint(v)
+	if str == "" || strings.ContainsAny(str, " =\"\t\n") {
+		return fmt.Sprintf("%q", str)
+	}
+	return str
+}
+
+// logger returns the config's Logger, or a NopLogger
+func (c *Config) logger() Logger {
+	if c.Logger == nil {
+		return NopLogger{}
+	}
+	return c.Logger
+}
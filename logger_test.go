@@ -0,0 +1,61 @@
+package cwpagedmetricput
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+type testLogger struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *testLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, fmt.Sprintf("%s %s %v", level, msg, keyvals))
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := StdLogger{Logger: log.New(&buf, "", 0), MinLevel: LevelInfo}
+	l.Log(LevelDebug, "hidden")
+	l.Log(LevelWarn, "dropped datum", "reason", DropReasonSendError, "err", errors.New("bad thing"), "odd")
+	require.Equal(t, `level=warn msg="dropped datum" reason=send_error err="bad thing" odd=(MISSING)`+"\n", buf.String())
+}
+
+func TestLogLevel_String(t *testing.T) {
+	require.Equal(t, "debug", LevelDebug.String())
+	require.Equal(t, "error", LevelError.String())
+	require.Equal(t, "level(9)", LogLevel(9).String())
+}
+
+func TestPager_Logger(t *testing.T) {
+	logger := &testLogger{}
+	p := Pager{
+		Client: &memoryCloudWatchClient{errOnCall: 1, err: errors.New("bad")},
+		Config: Config{
+			Logger:            logger,
+			ClearInvalidUnits: true,
+		},
+	}
+	_, err := p.PutMetricData(&cloudwatch.PutMetricDataInput{
+		Namespace: aws.String("ns"),
+		MetricData: []*cloudwatch.MetricDatum{
+			{MetricName: aws.String("m"), Value: aws.Float64(1), Unit: aws.String("bad")},
+		},
+	})
+	require.Error(t, err)
+	require.Equal(t, []string{
+		"debug cleared invalid unit [metric_name m unit bad]",
+		"warn dropped datum [reason send_error metric_name m err bad]",
+	}, logger.events)
+}
@@ -0,0 +1,326 @@
+package otlp
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+// units maps UCUM units, which OpenTelemetry uses, to CloudWatch units
+var units = map[string]string{
+	"s":      cloudwatch.StandardUnitSeconds,
+	"ms":     cloudwatch.StandardUnitMilliseconds,
+	"us":     cloudwatch.StandardUnitMicroseconds,
+	"By":     cloudwatch.StandardUnitBytes,
+	"kBy":    cloudwatch.StandardUnitKilobytes,
+	"MBy":    cloudwatch.StandardUnitMegabytes,
+	"GBy":    cloudwatch.StandardUnitGigabytes,
+	"TBy":    cloudwatch.StandardUnitTerabytes,
+	"bit":    cloudwatch.StandardUnitBits,
+	"kbit":   cloudwatch.StandardUnitKilobits,
+	"Mbit":   cloudwatch.StandardUnitMegabits,
+	"Gbit":   cloudwatch.StandardUnitGigabits,
+	"Tbit":   cloudwatch.StandardUnitTerabits,
+	"%":      cloudwatch.StandardUnitPercent,
+	"By/s":   cloudwatch.StandardUnitBytesSecond,
+	"kBy/s":  cloudwatch.StandardUnitKilobytesSecond,
+	"MBy/s":  cloudwatch.StandardUnitMegabytesSecond,
+	"GBy/s":  cloudwatch.StandardUnitGigabytesSecond,
+	"bit/s":  cloudwatch.StandardUnitBitsSecond,
+	"kbit/s": cloudwatch.StandardUnitKilobitsSecond,
+	"Mbit/s": cloudwatch.StandardUnitMegabitsSecond,
+	"Gbit/s": cloudwatch.StandardUnitGigabitsSecond,
+	"1/s":    cloudwatch.StandardUnitCountSecond,
+}
+
+// unitOf returns the CloudWatch unit of a UCUM unit, or nil if there is none.  Annotations like {requests} are counts.
+func unitOf(unit string) *string {
+	if u, exists := units[unit]; exists {
+		return aws.String(u)
+	}
+	if strings.HasPrefix(unit, "{") && strings.HasSuffix(unit, "}") {
+		return aws.String(cloudwatch.StandardUnitCount)
+	}
+	return nil
+}
+
+// attributeValue returns the string form of a scalar attribute, and false for arrays, maps, bytes, and empty values
+func attributeValue(v *commonpb.AnyValue) (string, bool) {
+	switch val := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return val.StringValue, val.StringValue != ""
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(val.BoolValue), true
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(val.IntValue, 10), true
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(val.DoubleValue, 'g', -1, 64), true
+	}
+	return "", false
+}
+
+// attributesKey is the same for equal attribute sets, whatever their order
+func attributesKey(attributes []*commonpb.KeyValue) string {
+	parts := make([]string, 0, len(attributes))
+	for _, kv := range attributes {
+		value, _ := attributeValue(kv.GetValue())
+		parts = append(parts, kv.GetKey()+"="+value)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "\x00")
+}
+
+// cumulativeState is the last cumulative point of a series
+type cumulativeState struct {
+	start uint64
+	// time is the point's TimeUnixNano
+	time  uint64
+	value float64
+	// counts are the cumulative count of each bucket's representative value, for histograms
+	counts map[float64]float64
+	seen   time.Time
+}
+
+// converter turns the metrics of one resource into datum.  It reads the previous cumulative points from state so it can
+// send deltas, and records each new point there.
+type converter struct {
+	state map[string]*cumulativeState
+	now   time.Time
+	// dimensions come from the resource and are added to every datum
+	dimensions []*cloudwatch.Dimension
+	// resourceKey identifies the resource in state
+	resourceKey string
+	// rejected counts points that cannot be converted
+	rejected int64
+}
+
+// previous records the latest cumulative point of a series and returns the point before it, or nil if there is nothing
+// to send because the series is new or next is no newer than the last point.  Skipping old points keeps state from
+// moving backwards when exports arrive out of order.  A series whose start time changed was reset, so it returns an
+// empty point and all of next is new.
+func (c *converter) previous(key string, start uint64, timeUnixNano uint64, next *cumulativeState) *cumulativeState {
+	last := c.state[key]
+	if last != nil && last.start == start && timeUnixNano != 0 && timeUnixNano <= last.time {
+		return nil
+	}
+	next.start = start
+	next.time = timeUnixNano
+	next.seen = c.now
+	c.state[key] = next
+	if last != nil && start != 0 && last.start != start {
+		return &cumulativeState{}
+	}
+	return last
+}
+
+func (c *converter) seriesKey(m *metricspb.Metric, attributes []*commonpb.KeyValue) string {
+	return c.resourceKey + "\x01" + m.GetName() + "\x01" + attributesKey(attributes)
+}
+
+func (c *converter) datum(m *metricspb.Metric, attributes []*commonpb.KeyValue, timeUnixNano uint64) *cloudwatch.MetricDatum {
+	timestamp := c.now
+	if timeUnixNano != 0 {
+		timestamp = time.Unix(0, int64(timeUnixNano))
+	}
+	ret := &cloudwatch.MetricDatum{
+		MetricName: aws.String(m.GetName()),
+		Timestamp:  aws.Time(timestamp),
+		Unit:       unitOf(m.GetUnit()),
+		Dimensions: append([]*cloudwatch.Dimension(nil), c.dimensions...),
+	}
+	for _, kv := range attributes {
+		if value, ok := attributeValue(kv.GetValue()); ok {
+			ret.Dimensions = append(ret.Dimensions, &cloudwatch.Dimension{
+				Name:  aws.String(kv.GetKey()),
+				Value: aws.String(value),
+			})
+		}
+	}
+	return ret
+}
+
+func noRecordedValue(flags uint32) bool {
+	return flags&uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) != 0
+}
+
+// convert returns the datum of m
+func (c *converter) convert(m *metricspb.Metric) []*cloudwatch.MetricDatum {
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		return c.numbers(m, data.Gauge.GetDataPoints(), false)
+	case *metricspb.Metric_Sum:
+		cumulative := data.Sum.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		// A cumulative sum that can go down, like an up down counter, is already a gauge
+		return c.numbers(m, data.Sum.GetDataPoints(), cumulative && data.Sum.GetIsMonotonic())
+	case *metricspb.Metric_Histogram:
+		cumulative := data.Histogram.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		var ret []*cloudwatch.MetricDatum
+		for _, p := range data.Histogram.GetDataPoints() {
+			if noRecordedValue(p.GetFlags()) {
+				continue
+			}
+			if d := c.distribution(m, p.GetAttributes(), p.GetStartTimeUnixNano(), p.GetTimeUnixNano(), histogramCounts(p), cumulative); d != nil {
+				ret = append(ret, d)
+			}
+		}
+		return ret
+	case *metricspb.Metric_ExponentialHistogram:
+		cumulative := data.ExponentialHistogram.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		var ret []*cloudwatch.MetricDatum
+		for _, p := range data.ExponentialHistogram.GetDataPoints() {
+			if noRecordedValue(p.GetFlags()) {
+				continue
+			}
+			if d := c.distribution(m, p.GetAttributes(), p.GetStartTimeUnixNano(), p.GetTimeUnixNano(), exponentialCounts(p), cumulative); d != nil {
+				ret = append(ret, d)
+			}
+		}
+		return ret
+	case *metricspb.Metric_Summary:
+		c.rejected += int64(len(data.Summary.GetDataPoints()))
+	}
+	return nil
+}
+
+// numbers converts gauge and sum points.  Points of a monotonic cumulative sum are sent as the delta since the last
+// point, so the first point of each series sends nothing.
+func (c *converter) numbers(m *metricspb.Metric, points []*metricspb.NumberDataPoint, cumulative bool) []*cloudwatch.MetricDatum {
+	var ret []*cloudwatch.MetricDatum
+	for _, p := range points {
+		if noRecordedValue(p.GetFlags()) {
+			continue
+		}
+		value := p.GetAsDouble()
+		if _, isInt := p.GetValue().(*metricspb.NumberDataPoint_AsInt); isInt {
+			value = float64(p.GetAsInt())
+		}
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			c.rejected++
+			continue
+		}
+		if cumulative {
+			last := c.previous(c.seriesKey(m, p.GetAttributes()), p.GetStartTimeUnixNano(), p.GetTimeUnixNano(), &cumulativeState{value: value})
+			if last == nil {
+				continue
+			}
+			if value >= last.value {
+				value -= last.value
+			}
+		}
+		d := c.datum(m, p.GetAttributes(), p.GetTimeUnixNano())
+		d.Value = aws.Float64(value)
+		ret = append(ret, d)
+	}
+	return ret
+}
+
+// distribution converts the bucket counts of a histogram point into Values and Counts.  Cumulative points send the
+// counts added since the last point.  It returns nil when there is nothing to send.
+func (c *converter) distribution(m *metricspb.Metric, attributes []*commonpb.KeyValue, start uint64, timeUnixNano uint64, counts map[float64]float64, cumulative bool) *cloudwatch.MetricDatum {
+	if cumulative {
+		last := c.previous(c.seriesKey(m, attributes), start, timeUnixNano, &cumulativeState{counts: counts})
+		if last == nil {
+			return nil
+		}
+		counts = deltaCounts(last.counts, counts)
+	}
+	values := make([]float64, 0, len(counts))
+	for v, count := range counts {
+		if count > 0 {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Float64s(values)
+	d := c.datum(m, attributes, timeUnixNano)
+	for _, v := range values {
+		d.Values = append(d.Values, aws.Float64(v))
+		d.Counts = append(d.Counts, aws.Float64(counts[v]))
+	}
+	return d
+}
+
+// deltaCounts returns what was added to each bucket.  If any bucket went down, the histogram reset and every count
+// is new.
+func deltaCounts(last map[float64]float64, next map[float64]float64) map[float64]float64 {
+	ret := make(map[float64]float64, len(next))
+	for v, count := range next {
+		delta := count - last[v]
+		if delta < 0 {
+			return next
+		}
+		ret[v] = delta
+	}
+	return ret
+}
+
+// histogramCounts maps the value that represents each explicit bucket to its count
+func histogramCounts(p *metricspb.HistogramDataPoint) map[float64]float64 {
+	bounds := p.GetExplicitBounds()
+	ret := make(map[float64]float64, len(p.GetBucketCounts()))
+	for i, count := range p.GetBucketCounts() {
+		if count == 0 {
+			continue
+		}
+		ret[bucketValue(i, bounds, p)] += float64(count)
+	}
+	return ret
+}
+
+// bucketValue returns the middle of explicit bucket i.  The first and last buckets are unbounded, so they use the
+// point's min and max when it has them, or else their one finite bound.
+func bucketValue(i int, bounds []float64, p *metricspb.HistogramDataPoint) float64 {
+	var lower, upper *float64
+	if i > 0 && i-1 < len(bounds) {
+		lower = &bounds[i-1]
+	} else if i == 0 {
+		lower = p.Min
+	}
+	if i < len(bounds) {
+		upper = &bounds[i]
+	} else {
+		upper = p.Max
+	}
+	switch {
+	case lower != nil && upper != nil:
+		return (*lower + *upper) / 2
+	case lower != nil:
+		return *lower
+	case upper != nil:
+		return *upper
+	case p.GetCount() != 0 && p.Sum != nil:
+		return *p.Sum / float64(p.GetCount())
+	}
+	return 0
+}
+
+// exponentialCounts maps the middle of each exponential bucket to its count.  Bucket index i covers
+// (base^i, base^(i+1)], where base is 2^(2^-scale).
+func exponentialCounts(p *metricspb.ExponentialHistogramDataPoint) map[float64]float64 {
+	base := math.Exp2(math.Exp2(-float64(p.GetScale())))
+	ret := make(map[float64]float64)
+	if p.GetZeroCount() != 0 {
+		ret[0] = float64(p.GetZeroCount())
+	}
+	add := func(buckets *metricspb.ExponentialHistogramDataPoint_Buckets, sign float64) {
+		for i, count := range buckets.GetBucketCounts() {
+			if count == 0 {
+				continue
+			}
+			lower := math.Pow(base, float64(int(buckets.GetOffset())+i))
+			ret[sign*lower*(1+base)/2] += float64(count)
+		}
+	}
+	add(p.GetPositive(), 1)
+	add(p.GetNegative(), -1)
+	return ret
+}
@@ -0,0 +1,121 @@
+package otlp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+func stringAttribute(key string, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func newConverter() *converter {
+	return &converter{state: make(map[string]*cumulativeState), now: time.Unix(1000, 0)}
+}
+
+// sumMetric returns a sum with a single point.  Larger values are given later times, like a counter that only grows.
+func sumMetric(temporality metricspb.AggregationTemporality, monotonic bool, start uint64, value int64) *metricspb.Metric {
+	return &metricspb.Metric{
+		Name: "requests",
+		Unit: "{requests}",
+		Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			AggregationTemporality: temporality,
+			IsMonotonic:            monotonic,
+			DataPoints: []*metricspb.NumberDataPoint{{
+				Attributes:        []*commonpb.KeyValue{stringAttribute("route", "home")},
+				StartTimeUnixNano: start,
+				TimeUnixNano:      uint64(time.Unix(2000, value).UnixNano()),
+				Value:             &metricspb.NumberDataPoint_AsInt{AsInt: value},
+			}},
+		}},
+	}
+}
+
+func Test_converterSum(t *testing.T) {
+	cumulative := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	t.Run("cumulative", func(t *testing.T) {
+		c := newConverter()
+		require.Empty(t, c.convert(sumMetric(cumulative, true, 1, 10)))
+		got := c.convert(sumMetric(cumulative, true, 1, 15))
+		require.Len(t, got, 1)
+		require.Equal(t, 5.0, *got[0].Value)
+		require.Equal(t, cloudwatch.StandardUnitCount, *got[0].Unit)
+		require.Equal(t, time.Unix(2000, 15), *got[0].Timestamp)
+		require.Equal(t, []*cloudwatch.Dimension{{Name: aws.String("route"), Value: aws.String("home")}}, got[0].Dimensions)
+
+		// Older and repeated points send nothing and are not remembered
+		require.Empty(t, c.convert(sumMetric(cumulative, true, 1, 12)))
+		require.Empty(t, c.convert(sumMetric(cumulative, true, 1, 15)))
+		got = c.convert(sumMetric(cumulative, true, 1, 18))
+		require.Equal(t, 3.0, *got[0].Value)
+
+		// A new start time is a reset
+		got = c.convert(sumMetric(cumulative, true, 2, 4))
+		require.Equal(t, 4.0, *got[0].Value)
+	})
+	t.Run("delta", func(t *testing.T) {
+		got := newConverter().convert(sumMetric(metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, true, 1, 10))
+		require.Equal(t, 10.0, *got[0].Value)
+	})
+	t.Run("up down counter", func(t *testing.T) {
+		got := newConverter().convert(sumMetric(cumulative, false, 1, 10))
+		require.Equal(t, 10.0, *got[0].Value)
+	})
+}
+
+func Test_converterHistogram(t *testing.T) {
+	histogram := func(temporality metricspb.AggregationTemporality, counts ...uint64) *metricspb.Metric {
+		return &metricspb.Metric{
+			Name: "latency",
+			Unit: "ms",
+			Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+				AggregationTemporality: temporality,
+				DataPoints: []*metricspb.HistogramDataPoint{{
+					StartTimeUnixNano: 1,
+					BucketCounts:      counts,
+					ExplicitBounds:    []float64{10, 20},
+					Min:               aws.Float64(2),
+					Max:               aws.Float64(50),
+				}},
+			}},
+		}
+	}
+	got := newConverter().convert(histogram(metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, 1, 0, 3))
+	require.Len(t, got, 1)
+	require.Equal(t, cloudwatch.StandardUnitMilliseconds, *got[0].Unit)
+	require.Equal(t, []*float64{aws.Float64(6), aws.Float64(35)}, got[0].Values)
+	require.Equal(t, []*float64{aws.Float64(1), aws.Float64(3)}, got[0].Counts)
+
+	c := newConverter()
+	cumulative := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	require.Empty(t, c.convert(histogram(cumulative, 1, 0, 3)))
+	got = c.convert(histogram(cumulative, 1, 2, 3))
+	require.Len(t, got, 1)
+	require.Equal(t, []*float64{aws.Float64(15)}, got[0].Values)
+	require.Equal(t, []*float64{aws.Float64(2)}, got[0].Counts)
+	require.Empty(t, c.convert(histogram(cumulative, 1, 2, 3)))
+}
+
+func Test_exponentialCounts(t *testing.T) {
+	got := exponentialCounts(&metricspb.ExponentialHistogramDataPoint{
+		Scale:     0,
+		ZeroCount: 1,
+		Positive:  &metricspb.ExponentialHistogramDataPoint_Buckets{Offset: 1, BucketCounts: []uint64{2, 0, 3}},
+		Negative:  &metricspb.ExponentialHistogramDataPoint_Buckets{Offset: 0, BucketCounts: []uint64{4}},
+	})
+	// Scale 0 has base 2, so bucket 1 is (2, 4] and bucket 3 is (8, 16]
+	require.Equal(t, map[float64]float64{0: 1, 3: 2, 12: 3, -1.5: 4}, got)
+}
+
+func Test_unitOf(t *testing.T) {
+	require.Equal(t, cloudwatch.StandardUnitBytes, *unitOf("By"))
+	require.Equal(t, cloudwatch.StandardUnitCount, *unitOf("{packets}"))
+	require.Nil(t, unitOf("1"))
+	require.Nil(t, unitOf(""))
+}
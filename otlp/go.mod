@@ -0,0 +1,28 @@
+module github.com/cep21/cwpagedmetricput/otlp
+
+go 1.24
+
+require (
+	github.com/aws/aws-sdk-go v1.21.6
+	github.com/cep21/cwpagedmetricput v0.0.0-20261018191921-cb6f90ec0a5c
+	github.com/stretchr/testify v1.3.0
+	go.opentelemetry.io/proto/otlp v1.9.0
+	google.golang.org/protobuf v1.36.10
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/grpc-ecosystem/grpc-gateway/v2 v2.27.2 // indirect
+	github.com/jmespath/go-jmespath v0.0.0-20180206201540-c2b33e8439af // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/net v0.43.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
+	google.golang.org/genproto/googleapis/api v0.0.0-20250825161204-c5933d9347a5 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250825161204-c5933d9347a5 // indirect
+	google.golang.org/grpc v1.75.1 // indirect
+)
+
+// The root module is developed in the same repository.  Releases require a root version that is already published,
+// so tag or push the root module before bumping this requirement.
+replace github.com/cep21/cwpagedmetricput => ../
@@ -0,0 +1,209 @@
+/*
+Package otlp is an OpenTelemetry OTLP/HTTP metrics receiver that publishes to CloudWatch through a
+cwpagedmetricput.Pager.
+*/
+package otlp
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/cep21/cwpagedmetricput"
+	"github.com/cep21/cwpagedmetricput/internal/defaults"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultMaxBodySize = 16 * 1024 * 1024
+	// staleAfter is how long a cumulative series is remembered after its last point
+	staleAfter = time.Hour
+	// pruneInterval is how often forgotten series are looked for
+	pruneInterval = time.Minute
+)
+
+const (
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+// Receiver accepts OTLP metrics and publishes them.  Gauges and non monotonic sums are sent as they are.  Delta sums
+// and histograms are sent as they are, while cumulative ones are sent as the change since the previous point of the
+// same series, so the first point of a cumulative series sends nothing.  Histogram and exponential histogram buckets
+// become Values and Counts, each bucket represented by its middle.  Point attributes become dimensions.  Summary
+// points are rejected.  Delivery of cumulative changes is at most once: a point is remembered as soon as it is converted,
+// so an export that fails, even partly, is not sent again when retried.  A point no newer than the last one of its
+// series, for example from exports that race or arrive out of order, sends nothing.
+type Receiver struct {
+	// Client receives the metrics.  It is required and is usually a *cwpagedmetricput.Pager.
+	Client cwpagedmetricput.CloudWatchClient
+	// Namespace of every metric.  It is required.
+	Namespace string
+	// ResourceDimensions are the resource attributes, like service.name, that become dimensions
+	ResourceDimensions []string
+	// MaxBodySize limits the size of a decompressed request body.  Defaults to 16MB.
+	MaxBodySize int64
+	// Logger receives errors from ServeHTTP.  Defaults to cwpagedmetricput.NopLogger.
+	Logger cwpagedmetricput.Logger
+
+	mu sync.Mutex
+	// state is the last point of each cumulative series
+	state     map[string]*cumulativeState
+	lastPrune time.Time
+	// now is time.Now, but can be replaced for testing
+	now func() time.Time
+}
+
+var _ http.Handler = &Receiver{}
+
+func (r *Receiver) maxBodySize() int64 {
+	if r.MaxBodySize <= 0 {
+		return defaultMaxBodySize
+	}
+	return r.MaxBodySize
+}
+
+// Export converts and publishes the metrics of req.  Points that cannot be converted are counted in the response's
+// partial success.
+func (r *Receiver) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	datum, rejected := r.convert(req)
+	if len(datum) != 0 {
+		if _, err := r.Client.PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(r.Namespace),
+			MetricData: datum,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	resp := &colmetricspb.ExportMetricsServiceResponse{}
+	if rejected != 0 {
+		resp.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: rejected,
+			ErrorMessage:       "summary, NaN, and infinite points are not supported",
+		}
+	}
+	return resp, nil
+}
+
+// convert returns the datum of req and how many points were rejected
+func (r *Receiver) convert(req *colmetricspb.ExportMetricsServiceRequest) ([]*cloudwatch.MetricDatum, int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := defaults.Now(r.now)
+	if r.state == nil {
+		r.state = make(map[string]*cumulativeState)
+	}
+	if now.Sub(r.lastPrune) > pruneInterval {
+		for key, s := range r.state {
+			if now.Sub(s.seen) > staleAfter {
+				delete(r.state, key)
+			}
+		}
+		r.lastPrune = now
+	}
+	var datum []*cloudwatch.MetricDatum
+	var rejected int64
+	for _, rm := range req.GetResourceMetrics() {
+		c := converter{
+			state:       r.state,
+			now:         now,
+			resourceKey: attributesKey(rm.GetResource().GetAttributes()),
+		}
+		for _, name := range r.ResourceDimensions {
+			for _, kv := range rm.GetResource().GetAttributes() {
+				if value, ok := attributeValue(kv.GetValue()); ok && kv.GetKey() == name {
+					c.dimensions = append(c.dimensions, &cloudwatch.Dimension{
+						Name:  aws.String(name),
+						Value: aws.String(value),
+					})
+				}
+			}
+		}
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				datum = append(datum, c.convert(m)...)
+			}
+		}
+		rejected += c.rejected
+	}
+	return datum, rejected
+}
+
+// ServeHTTP handles OTLP/HTTP export requests, usually mounted at /v1/metrics.  Bodies may be protobuf or JSON, and
+// may be gzip encoded.  The response uses the encoding of the request.
+func (r *Receiver) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	contentType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || (contentType != contentTypeProtobuf && contentType != contentTypeJSON) {
+		http.Error(rw, "content type must be application/x-protobuf or application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := r.readBody(rw, req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	exportReq := &colmetricspb.ExportMetricsServiceRequest{}
+	if contentType == contentTypeJSON {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, exportReq)
+	} else {
+		err = proto.Unmarshal(body, exportReq)
+	}
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("unable to decode request: %s", err), http.StatusBadRequest)
+		return
+	}
+	resp, err := r.Export(req.Context(), exportReq)
+	if err != nil {
+		defaults.Logger(r.Logger).Log(cwpagedmetricput.LevelWarn, "unable to publish otlp metrics", "err", err)
+		// 503 tells OTLP clients to retry
+		http.Error(rw, "unable to publish metrics", http.StatusServiceUnavailable)
+		return
+	}
+	var out []byte
+	if contentType == contentTypeJSON {
+		out, err = protojson.Marshal(resp)
+	} else {
+		out, err = proto.Marshal(resp)
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", contentType)
+	if _, err := rw.Write(out); err != nil {
+		defaults.Logger(r.Logger).Log(cwpagedmetricput.LevelDebug, "unable to write otlp response", "err", err)
+	}
+}
+
+func (r *Receiver) readBody(rw http.ResponseWriter, req *http.Request) ([]byte, error) {
+	var body io.Reader = req.Body
+	switch req.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = gz.Close()
+		}()
+		body = gz
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %s", req.Header.Get("Content-Encoding"))
+	}
+	return io.ReadAll(http.MaxBytesReader(rw, io.NopCloser(body), r.maxBodySize()))
+}
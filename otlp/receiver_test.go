@@ -0,0 +1,178 @@
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/cep21/cwpagedmetricput/internal/cwtest"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func exportRequest() *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				stringAttribute("service.name", "api"),
+				stringAttribute("host.id", "i-123"),
+			}},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Metrics: []*metricspb.Metric{
+					{
+						Name: "queue",
+						Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{
+							{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 3}},
+						}}},
+					},
+					{
+						Name: "rpc",
+						Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{DataPoints: []*metricspb.SummaryDataPoint{{}}}},
+					},
+				},
+			}},
+		}},
+	}
+}
+
+func TestReceiver_ServeHTTP(t *testing.T) {
+	protoBody, err := proto.Marshal(exportRequest())
+	require.NoError(t, err)
+	jsonBody, err := protojson.Marshal(exportRequest())
+	require.NoError(t, err)
+	var gzipBody bytes.Buffer
+	gz := gzip.NewWriter(&gzipBody)
+	_, err = gz.Write(protoBody)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		encoding    string
+		body        []byte
+		clientErr   error
+		wantStatus  int
+	}{
+		{name: "protobuf", contentType: contentTypeProtobuf, body: protoBody, wantStatus: http.StatusOK},
+		{name: "json", contentType: contentTypeJSON + "; charset=utf-8", body: jsonBody, wantStatus: http.StatusOK},
+		{name: "gzip", contentType: contentTypeProtobuf, encoding: "gzip", body: gzipBody.Bytes(), wantStatus: http.StatusOK},
+		{name: "get", method: http.MethodGet, contentType: contentTypeProtobuf, wantStatus: http.StatusMethodNotAllowed},
+		{name: "text", contentType: "text/plain", body: protoBody, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "bad body", contentType: contentTypeJSON, body: []byte("{"), wantStatus: http.StatusBadRequest},
+		{name: "client error", contentType: contentTypeProtobuf, body: protoBody, clientErr: errors.New("down"), wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &cwtest.Client{}
+			client.SetErr(tt.clientErr)
+			r := &Receiver{Client: client, Namespace: "ns", ResourceDimensions: []string{"service.name"}}
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req := httptest.NewRequest(method, "/v1/metrics", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+			require.Equal(t, tt.wantStatus, rw.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			require.Len(t, client.Inputs(), 1)
+			require.Equal(t, "ns", *client.Inputs()[0].Namespace)
+			d := client.Inputs()[0].MetricData[0]
+			require.Equal(t, "queue", *d.MetricName)
+			require.Equal(t, 3.0, *d.Value)
+			require.Equal(t, []*cloudwatch.Dimension{{Name: aws.String("service.name"), Value: aws.String("api")}}, d.Dimensions)
+
+			body, err := io.ReadAll(rw.Body)
+			require.NoError(t, err)
+			resp := &colmetricspb.ExportMetricsServiceResponse{}
+			if strings.HasPrefix(tt.contentType, contentTypeJSON) {
+				require.Equal(t, contentTypeJSON, rw.Header().Get("Content-Type"))
+				require.NoError(t, protojson.Unmarshal(body, resp))
+			} else {
+				require.NoError(t, proto.Unmarshal(body, resp))
+			}
+			// The summary point
+			require.Equal(t, int64(1), resp.GetPartialSuccess().GetRejectedDataPoints())
+		})
+	}
+}
+
+func sumRequest(value int64) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Metrics: []*metricspb.Metric{sumMetric(metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, true, 1, value)},
+			}},
+		}},
+	}
+}
+
+func TestReceiver_Export_retry(t *testing.T) {
+	client := &cwtest.Client{}
+	r := &Receiver{Client: client, Namespace: "ns"}
+	_, err := r.Export(context.Background(), sumRequest(10))
+	require.NoError(t, err)
+	require.Empty(t, client.Inputs())
+
+	client.SetErr(errors.New("unavailable"))
+	_, err = r.Export(context.Background(), sumRequest(15))
+	require.Error(t, err)
+	// Delivery is at most once, so the retried export sends nothing
+	client.SetErr(nil)
+	_, err = r.Export(context.Background(), sumRequest(15))
+	require.NoError(t, err)
+	require.Empty(t, client.Inputs())
+
+	_, err = r.Export(context.Background(), sumRequest(18))
+	require.NoError(t, err)
+	require.Len(t, client.Inputs(), 1)
+	require.Equal(t, 3.0, *client.Inputs()[0].MetricData[0].Value)
+}
+
+func TestReceiver_Export_concurrent(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		client := &cwtest.Client{}
+		r := &Receiver{Client: client, Namespace: "ns"}
+		_, err := r.Export(context.Background(), sumRequest(0))
+		require.NoError(t, err)
+		wg := sync.WaitGroup{}
+		for _, value := range []int64{10, 20} {
+			wg.Add(1)
+			go func(value int64) {
+				defer wg.Done()
+				_, err := r.Export(context.Background(), sumRequest(value))
+				require.NoError(t, err)
+			}(value)
+		}
+		wg.Wait()
+		// Whichever order the exports run in, the published changes add up to the counter's growth
+		total := 0.0
+		for _, d := range client.Datum() {
+			total += *d.Value
+		}
+		require.Equal(t, 20.0, total)
+	}
+}
@@ -4,7 +4,11 @@ import (
 	"context"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
 	"github.com/aws/aws-sdk-go/aws/request"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -15,12 +19,46 @@ import (
 type Config struct {
 	// True will empty out the "unit" field of datum that have a unit not explicitly documented at
 	// https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/API_MetricDatum.html
+	// It runs as the first Stage, before anything in Stages.
 	ClearInvalidUnits bool
+	// DefaultDimensions are added to every datum, before Stages run.  The caller's datum are never modified.  Defaults
+	// that would push a datum past CloudWatch's limit of 30 dimensions are not added.
+	DefaultDimensions []*cloudwatch.Dimension
+	// DefaultDimensionConflict controls which value is kept when a datum already has one of the DefaultDimensions
+	DefaultDimensionConflict DimensionConflict
+	// Stages run, in order, on every PutMetricDataInput before its datum are split and bucketed.  Use them to filter,
+	// rename, or remap datum.  Rules.Stages can build them from a config file.
+	Stages []Stage
+	// Rollups publish an extra, aggregated copy of every datum with only some of its dimensions.  They run after Stages.
+	Rollups []Rollup
+	// CardinalityGuard, if set, limits the distinct metrics published to each namespace.  It runs last, after Rollups.
+	CardinalityGuard *CardinalityGuard
 	// True will *not* use goroutines to send all the batches at once and will send the batches serially after they are
 	// created
 	SerialSends bool
+	// CompressionLevel is the gzip level request bodies are compressed with.  The zero value uses
+	// gzip.DefaultCompression.
+	CompressionLevel int
+	// DisableCompression sends request bodies uncompressed, for proxies and emulators that reject
+	// Content-Encoding: gzip
+	DisableCompression bool
+	// Encoder, if set, encodes request bodies instead of gzip.  It takes priority over CompressionLevel and
+	// DisableCompression.
+	Encoder BodyEncoder
+	// True will retry a bucket uncompressed when the endpoint rejects its encoded body with a 4xx, as some emulators
+	// and proxies do.  Once an uncompressed retry succeeds, every later request is sent uncompressed.
+	CompressionFallback bool
+	// Logger, if set, receives diagnostic events for splits, drops, retries, and cleared units
+	Logger Logger
+	// Tracer, if set, starts spans for each PutMetricData call, each bucket sent, and each bisection of a bucket
+	Tracer Tracer
+	// Budget, if set, limits what is sent to CloudWatch each period and degrades what is sent once exceeded
+	Budget *Budget
+	// True will plan each call with Pager.Plan and log the requests it would send at LevelInfo, instead of sending
+	// them.  Nothing is counted in Stats or Usage.
+	DryRun bool
 	// Callback executed when weird datum or RPC calls force us to drop some of the datum from a request we've had to
-	// split.
+	// split.  It is not called for datum published by a SelfReporter.
 	OnDroppedDatum func(datum *cloudwatch.MetricDatum)
 }
 
@@ -37,22 +75,37 @@ var _ CloudWatchClient = &Pager{}
 // Pager behaves like CloudWatch's MetricData API, but takes care of all of the smaller parts for you around
 // how to correctly bucket and split MetricDatum.
 // Pager is as thread safe as the Client parameter.  If you're using *cloudwatch.CloudWatch as your
-// Client, then it will be thread safe.
+// Client, then it will be thread safe.  Pager should not be copied after first use.
+// Pager never modifies the input passed to it, or anything the input points to, so callers may reuse or read their
+// input concurrently with a send.
 type Pager struct {
 	// Client is required and is usually an instance of *cloudwatch.CloudWatch
 	Client CloudWatchClient
 	// Config is optional and controls how data is filtered or aggregated
 	Config Config
+
+	usage               usageTracker
+	statsOnce           sync.Once
+	pagerStats          *pagerStats
+	compressionRejected int32
 }
 
 // onDroppedDatum optionally calls the Config's OnDroppedDatum if the API splits a request and is unable
-// to send all the datum.
-func (c *Pager) onDroppedDatum(datum *cloudwatch.MetricDatum) {
-	if c.Config.OnDroppedDatum != nil {
+// to send all the datum.  The callback is skipped for the Pager's own self metrics, so a callback that publishes
+// metrics cannot recurse.
+func (c *Pager) onDroppedDatum(ctx context.Context, reason DropReason, datum *cloudwatch.MetricDatum, err error) {
+	c.statsFor(ctx).drop(reason, 1)
+	c.Config.logger().Log(LevelWarn, "dropped datum", "reason", reason, "metric_name", aws.StringValue(datum.MetricName), "err", err)
+	if c.Config.OnDroppedDatum != nil && !isSelfMetrics(ctx) {
 		c.Config.OnDroppedDatum(datum)
 	}
 }
 
+// onPayloadSize records the size of each request body sent
+func (c *Pager) onPayloadSize(size int64) {
+	c.usage.addPayload(c.Config.usagePeriod(), size)
+}
+
 // onGo is a `go` alternative that we call to abstract out if a function should execute serially or in concurrently.
 func (c *Pager) onGo(f func(errIdx int, bucket []*cloudwatch.MetricDatum), errIdx int, bucket []*cloudwatch.MetricDatum) {
 	if c.Config.SerialSends {
@@ -64,11 +117,79 @@ func (c *Pager) onGo(f func(errIdx int, bucket []*cloudwatch.MetricDatum), errId
 
 // PutMetricData should be a drop in replacement for *cloudwatch.CloudWatch.PutMetricData, but
 // taking care of splitting datum that are too large.
-// Note: More difficult to support PutMetricDataRequest since it is not one request.Request, but many.
 func (c *Pager) PutMetricData(input *cloudwatch.PutMetricDataInput) (*cloudwatch.PutMetricDataOutput, error) {
 	return c.PutMetricDataWithContext(context.Background(), input)
 }
 
+// pagedSendHandlerName names the Send handler of requests from PutMetricDataRequest
+const pagedSendHandlerName = "cwpagedmetricput.pagedSend"
+
+// pagedHandlersName names the handlers that move a composite request's handlers to its underlying requests
+const pagedHandlersName = "cwpagedmetricput.pagedHandlers"
+
+// PutMetricDataRequest should be a drop in replacement for *cloudwatch.CloudWatch.PutMetricDataRequest.  Paging
+// can send many requests, so the returned request is a composite: its Send executes the whole paged operation with
+// PutMetricDataWithContext, using the request's context and Params.  Validate and Complete handlers added to the
+// composite run once around the whole operation.  Build, Sign, and response handlers added to the composite run on
+// each underlying request instead, which Client builds, signs, and retries as usual.
+func (c *Pager) PutMetricDataRequest(input *cloudwatch.PutMetricDataInput) (*request.Request, *cloudwatch.PutMetricDataOutput) {
+	if input == nil {
+		input = &cloudwatch.PutMetricDataInput{}
+	}
+	output := &cloudwatch.PutMetricDataOutput{}
+	// perRequest holds the composite's handlers that belong on each underlying request
+	var perRequest request.Handlers
+	handlers := request.Handlers{}
+	handlers.Validate.PushBackNamed(request.NamedHandler{Name: pagedHandlersName, Fn: func(r *request.Request) {
+		// Validate runs before any of these lists, so they never run on the composite itself
+		perRequest = r.Handlers.Copy()
+		r.Handlers.Build.Clear()
+		r.Handlers.Sign.Clear()
+		r.Handlers.UnmarshalMeta.Clear()
+		r.Handlers.ValidateResponse.Clear()
+		r.Handlers.Unmarshal.Clear()
+		r.Handlers.UnmarshalError.Clear()
+	}})
+	handlers.Send.PushBackNamed(request.NamedHandler{Name: pagedSendHandlerName, Fn: func(r *request.Request) {
+		params, _ := r.Params.(*cloudwatch.PutMetricDataInput)
+		_, r.Error = c.PutMetricDataWithContext(r.Context(), params, handlersOption(perRequest))
+	}})
+	op := &request.Operation{
+		Name:       "PutMetricData",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	clientInfo := metadata.ClientInfo{
+		ServiceName: cloudwatch.ServiceName,
+		ServiceID:   cloudwatch.ServiceID,
+		APIVersion:  "2010-08-01",
+	}
+	// Underlying requests retry on their own, so the composite never does
+	req := request.New(aws.Config{}, clientInfo, handlers, client.DefaultRetryer{NumMaxRetries: 0}, op, input, output)
+	return req, output
+}
+
+// handlersOption returns a request.Option that runs the Build, Sign, and response handlers of h after those of the
+// request
+func handlersOption(h request.Handlers) request.Option {
+	push := func(list *request.HandlerList, from request.HandlerList) {
+		if from.Len() == 0 {
+			return
+		}
+		handler := request.NamedHandler{Name: pagedHandlersName, Fn: from.Run}
+		list.Remove(handler)
+		list.PushBackNamed(handler)
+	}
+	return func(req *request.Request) {
+		push(&req.Handlers.Build, h.Build)
+		push(&req.Handlers.Sign, h.Sign)
+		push(&req.Handlers.UnmarshalMeta, h.UnmarshalMeta)
+		push(&req.Handlers.ValidateResponse, h.ValidateResponse)
+		push(&req.Handlers.Unmarshal, h.Unmarshal)
+		push(&req.Handlers.UnmarshalError, h.UnmarshalError)
+	}
+}
+
 // PutMetricDataWithContext should be a drop in replacement for *cloudwatch.CloudWatch.PutMetricDataWithContext, but
 // taking care of splitting datum that are too large.
 func (c *Pager) PutMetricDataWithContext(ctx aws.Context, input *cloudwatch.PutMetricDataInput, reqs ...request.Option) (*cloudwatch.PutMetricDataOutput, error) {
@@ -76,55 +197,85 @@ func (c *Pager) PutMetricDataWithContext(ctx aws.Context, input *cloudwatch.PutM
 		// Fallback behaviour is whatever the client does for nil input
 		return c.Client.PutMetricDataWithContext(ctx, input)
 	}
+	if c.Config.DryRun {
+		return &cloudwatch.PutMetricDataOutput{}, c.dryRun(input)
+	}
+	ctx, span := c.startSpan(ctx, SpanPutMetricData)
+	defer span.End()
+	span.SetAttribute(AttributeNamespace, aws.StringValue(input.Namespace))
+	span.SetAttribute(AttributeDatumCount, len(input.MetricData))
+	stats := c.statsFor(ctx)
+	atomic.AddInt64(&stats.calls, 1)
+	atomic.AddInt64(&stats.datumIn, int64(len(input.MetricData)))
 	// Appending gzip is optional but useful to reduce the total size of the request
 	// Also save you money since you are billed per request.
-	reqs = append(reqs, gzipBody)
+	enc := c.encoder()
+	reqs = append(reqs, encodeBodyOption(enc, putMetricDataKBRequestSizeLimit, stats.onCompress), ReportBodySize(c.onPayloadSize), retriesOption(c.onRetries))
 	// Process optional rules first
-	if c.Config.ClearInvalidUnits {
-		for i := range input.MetricData {
-			input.MetricData[i] = clearInvalidUnits(input.MetricData[i])
-		}
+	if isSelfMetrics(ctx) {
+		// Self metrics must still arrive when the caller's rules, guard, or budget are dropping everything else
+		input = c.processStages(input, c.Config.selfMetricsStages(), DropReasonFiltered)
+	} else {
+		input = c.applyBudget(c.runStages(input))
+	}
+	if input == nil || len(input.MetricData) == 0 {
+		// Everything was filtered out
+		return &cloudwatch.PutMetricDataOutput{}, nil
 	}
+	c.usage.addMetrics(c.Config.usagePeriod(), input)
 
 	// Split each individual datum that has too many .Values items into multiple datum
-	splitDatum := make([]*cloudwatch.MetricDatum, 0, len(input.MetricData))
-	for _, d := range input.MetricData {
-		splitDatum = append(splitDatum, splitLargeValueArray(d)...)
-	}
+	split := splitDatum(input.MetricData)
+	atomic.AddInt64(&stats.datumSplit, int64(len(split)))
 
 	// Split too many datum inside this call into multiple calls
-	buckets := bucketDatum(splitDatum)
+	buckets := bucketDatum(split)
+	atomic.AddInt64(&stats.buckets, int64(len(buckets)))
+	span.SetAttribute(AttributeBucketCount, len(buckets))
 
 	// Send all the datum at once
-	err := c.sendBuckets(ctx, input.Namespace, buckets, reqs)
+	err := c.sendBuckets(ctx, SpanSendBucket, input.Namespace, buckets, enc, reqs)
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
 	return &cloudwatch.PutMetricDataOutput{}, nil
 }
 
 // sendBuckets executes sendDatum on all the buckets in parallel.  It returns when all buckets finish executing.
-func (c *Pager) sendBuckets(ctx context.Context, namespace *string, buckets [][]*cloudwatch.MetricDatum, reqs []request.Option) error {
+// Each bucket is traced with a span named spanName.
+func (c *Pager) sendBuckets(ctx context.Context, spanName string, namespace *string, buckets [][]*cloudwatch.MetricDatum, enc BodyEncoder, reqs []request.Option) error {
 	errs := make([]error, len(buckets))
 	wg := sync.WaitGroup{}
 	for i, bucket := range buckets {
 		wg.Add(1)
 		c.onGo(func(errIdx int, bucket []*cloudwatch.MetricDatum) {
 			defer wg.Done()
-			errs[errIdx] = c.sendDatum(ctx, namespace, bucket, reqs)
+			errs[errIdx] = c.sendDatum(ctx, spanName, namespace, bucket, enc, reqs)
 		}, i, bucket)
 	}
 	wg.Wait()
 	return consolidateErr(errs)
 }
 
-// clearInvalidUnits returns datum with Unit fields filtered of invalid values
+// clearInvalidUnits returns datum with Unit fields filtered of invalid values.  datum is never modified: a datum with an
+// invalid unit is copied.
 func clearInvalidUnits(datum *cloudwatch.MetricDatum) *cloudwatch.MetricDatum {
-	if datum == nil || datum.Unit == nil {
+	if datum == nil || datum.Unit == nil || filterInvalidUnit(datum.Unit) != nil {
 		return datum
 	}
-	datum.Unit = filterInvalidUnit(datum.Unit)
-	return datum
+	ret := *datum
+	ret.Unit = nil
+	return &ret
+}
+
+// splitDatum runs splitLargeValueArray on every datum
+func splitDatum(datum []*cloudwatch.MetricDatum) []*cloudwatch.MetricDatum {
+	ret := make([]*cloudwatch.MetricDatum, 0, len(datum))
+	for _, d := range datum {
+		ret = append(ret, splitLargeValueArray(d)...)
+	}
+	return ret
 }
 
 // Documented on https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/API_PutMetricData.html under
@@ -132,7 +283,8 @@ func clearInvalidUnits(datum *cloudwatch.MetricDatum) *cloudwatch.MetricDatum {
 const maxValuesSize = 150
 
 // splitLargeValueArray splits a single datum if the size of the values array is larger than CloudWatch's
-// API allows.  It also takes care of correcting the StatisticValues set for the split datum.
+// API allows.  It also takes care of correcting the StatisticValues set for the split datum.  Split datum get their own
+// Values and Counts arrays, so they never share backing arrays with in.
 func splitLargeValueArray(in *cloudwatch.MetricDatum) []*cloudwatch.MetricDatum {
 	if in == nil {
 		return nil
@@ -147,9 +299,9 @@ func splitLargeValueArray(in *cloudwatch.MetricDatum) []*cloudwatch.MetricDatum
 		lastSizeDatum := lastDatum
 		// Notice how each lastSizeDatum does not have a StatisticValues set.
 		// See below for loop.
-		lastSizeDatum.Values = lastDatum.Values[0:maxValuesSize]
+		lastSizeDatum.Values = copyFloats(lastDatum.Values[0:maxValuesSize])
 		if lastSizeDatum.Counts != nil {
-			lastSizeDatum.Counts = lastDatum.Counts[0:maxValuesSize]
+			lastSizeDatum.Counts = copyFloats(lastDatum.Counts[0:maxValuesSize])
 		}
 		ret = append(ret, &lastSizeDatum)
 		lastDatum.Values = lastDatum.Values[maxValuesSize:]
@@ -157,6 +309,10 @@ func splitLargeValueArray(in *cloudwatch.MetricDatum) []*cloudwatch.MetricDatum
 			lastDatum.Counts = lastDatum.Counts[maxValuesSize:]
 		}
 	}
+	lastDatum.Values = copyFloats(lastDatum.Values)
+	if lastDatum.Counts != nil {
+		lastDatum.Counts = copyFloats(lastDatum.Counts)
+	}
 	if in.StatisticValues != nil && len(ret) < int(*in.StatisticValues.SampleCount) {
 		// Honestly not sure what to do here .... what is cloudwatch thinking?
 		// It isn't well documented on the site, but the right behaviour here according to
@@ -179,6 +335,13 @@ func splitLargeValueArray(in *cloudwatch.MetricDatum) []*cloudwatch.MetricDatum
 	return ret
 }
 
+// copyFloats returns a copy of in with its own backing array
+func copyFloats(in []*float64) []*float64 {
+	ret := make([]*float64, len(in))
+	copy(ret, in)
+	return ret
+}
+
 // Documented on https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/API_PutMetricData.html under
 // "Each request is also limited to no more than 20 different metrics"
 const maxDatumSize = 20
@@ -197,33 +360,78 @@ func bucketDatum(in []*cloudwatch.MetricDatum) [][]*cloudwatch.MetricDatum {
 
 // sendDatum will construct PutMetricDataInput objects and send them to c.Client.  If any of these sends fail because
 // the sent request body would be too big, the datum array is split into halves and sent separately.
-func (c *Pager) sendDatum(ctx context.Context, namespace *string, datum []*cloudwatch.MetricDatum, reqs []request.Option) error {
+func (c *Pager) sendDatum(ctx context.Context, spanName string, namespace *string, datum []*cloudwatch.MetricDatum, enc BodyEncoder, reqs []request.Option) error {
 	if len(datum) == 0 {
 		return nil
 	}
-	_, err := c.Client.PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
-		MetricData: datum,
-		Namespace:  namespace,
-	}, reqs...)
+	ctx, span := c.startSpan(ctx, spanName)
+	defer span.End()
+	span.SetAttribute(AttributeDatumCount, len(datum))
+	if c.Config.Tracer != nil {
+		// Copy so concurrent buckets don't share the appended option
+		reqs = append(reqs[:len(reqs):len(reqs)], traceOption(span))
+	}
+	err := c.sendDatumOnce(ctx, namespace, datum, enc, reqs)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return err
+}
+
+// sendDatumOnce sends datum in a single request, encoded by enc, bisecting it if the request is too large
+func (c *Pager) sendDatumOnce(ctx context.Context, namespace *string, datum []*cloudwatch.MetricDatum, enc BodyEncoder, reqs []request.Option) error {
+	err := c.put(ctx, namespace, datum, reqs)
+	if err != nil && c.shouldFallback(enc, err) {
+		// The later option replaces the encoder in place, since both use the same handler name
+		identityReqs := append(reqs[:len(reqs):len(reqs)], encodeBodyOption(IdentityEncoder{}, putMetricDataKBRequestSizeLimit, c.statsFor(ctx).onCompress))
+		c.Config.logger().Log(LevelInfo, "compressed request rejected, retrying uncompressed", "err", err)
+		identityErr := c.put(ctx, namespace, datum, identityReqs)
+		if identityErr == nil {
+			c.rejectCompression(err)
+			return nil
+		}
+		if IsRequestSizeError(identityErr) {
+			// Uncompressed is too large, so bisect.  The smaller halves will fall back again.
+			err = identityErr
+		}
+	}
 	if err == nil {
 		return nil
 	}
-	if _, isRequestSizeErr := err.(requestSizeError); isRequestSizeErr {
+	_, isRequestSizeErr := err.(requestSizeError)
+	if isRequestSizeErr {
 		// Split the request
 		if len(datum) == 1 {
 			// Even a single datum is too large.  This is very strange.  The best we can do is drop this
 			// single datum.  It will never work.
-			c.onDroppedDatum(datum[0])
+			c.Config.logger().Log(LevelError, "single datum too large to send", "metric_name", aws.StringValue(datum[0].MetricName), "err", err)
+			c.onDroppedDatum(ctx, DropReasonTooLarge, datum[0], err)
 			return err
 		}
+		atomic.AddInt64(&c.statsFor(ctx).bisections, 1)
+		c.Config.logger().Log(LevelDebug, "request too large, splitting in half", "datum_count", len(datum), "err", err)
 		mid := len(datum) / 2
 		datums := [][]*cloudwatch.MetricDatum{
 			datum[0:mid], datum[mid:],
 		}
-		return c.sendBuckets(ctx, namespace, datums, reqs)
+		return c.sendBuckets(ctx, SpanBisect, namespace, datums, enc, reqs)
 	}
 	for _, d := range datum {
-		c.onDroppedDatum(d)
+		c.onDroppedDatum(ctx, DropReasonSendError, d, err)
+	}
+	return err
+}
+
+// put sends datum in a single request, recording its latency and usage
+func (c *Pager) put(ctx context.Context, namespace *string, datum []*cloudwatch.MetricDatum, reqs []request.Option) error {
+	start := time.Now()
+	_, err := c.Client.PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
+		MetricData: datum,
+		Namespace:  namespace,
+	}, reqs...)
+	c.statsFor(ctx).observeLatency(time.Since(start))
+	if !IsRequestSizeError(err) {
+		c.usage.addRequest(c.Config.usagePeriod())
 	}
 	return err
 }
@@ -1,6 +1,9 @@
 package cwpagedmetricput_test
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
@@ -22,3 +25,23 @@ func ExamplePager_PutMetricData() {
 	})
 	// Output:
 }
+
+func ExampleGzipBody() {
+	client := cloudwatch.New(session.Must(session.NewSession()))
+	var compressedSize int64
+	_, err := client.PutMetricDataWithContext(context.Background(), &cloudwatch.PutMetricDataInput{
+		Namespace: aws.String("custom"),
+		MetricData: []*cloudwatch.MetricDatum{
+			{
+				MetricName: aws.String("custom metric"),
+				Value:      aws.Float64(1.0),
+			},
+		},
+	}, cwpagedmetricput.GzipBody, cwpagedmetricput.ReportBodySize(func(size int64) {
+		compressedSize = size
+	}))
+	if cwpagedmetricput.IsRequestSizeError(err) {
+		fmt.Println("split the datum and try again")
+	}
+	fmt.Println(compressedSize)
+}
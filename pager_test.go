@@ -1,11 +1,16 @@
 package cwpagedmetricput
 
 import (
+	"context"
 	"errors"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/stretchr/testify/require"
 )
@@ -198,3 +203,174 @@ func Test_splitLargeValueArray(t *testing.T) {
 		})
 	}
 }
+
+func Test_clearInvalidUnitsCopies(t *testing.T) {
+	in := &cloudwatch.MetricDatum{
+		MetricName: aws.String("m"),
+		Unit:       aws.String("Second"),
+	}
+	out := clearInvalidUnits(in)
+	require.Nil(t, out.Unit)
+	require.Equal(t, "Second", *in.Unit)
+	require.Equal(t, in.MetricName, out.MetricName)
+}
+
+func Test_splitLargeValueArrayCopies(t *testing.T) {
+	var in cloudwatch.MetricDatum
+	makeDatum(&in, randoms(400, 1024*1024, 2))
+	in.Counts = make([]*float64, len(in.Values))
+	for i := range in.Counts {
+		in.Counts[i] = aws.Float64(1)
+	}
+	for _, d := range splitLargeValueArray(&in) {
+		for i := range in.Values {
+			require.False(t, &d.Values[0] == &in.Values[i], "values backing array shared")
+			require.False(t, &d.Counts[0] == &in.Counts[i], "counts backing array shared")
+		}
+	}
+}
+
+// Run with -race to prove sends never write to the caller's input
+func TestPager_doesNotMutateInput(t *testing.T) {
+	in := &cloudwatch.PutMetricDataInput{
+		Namespace: aws.String("ns"),
+	}
+	for i := 0; i < 30; i++ {
+		d := &cloudwatch.MetricDatum{
+			MetricName: aws.String("metric"),
+			Unit:       aws.String("NotAUnit"),
+			Dimensions: []*cloudwatch.Dimension{
+				{Name: aws.String("host"), Value: aws.String("a")},
+				{Name: aws.String("id"), Value: aws.String(randomString(8))},
+			},
+			StorageResolution: aws.Int64(1),
+		}
+		makeDatum(d, randoms(400, 1024, 2))
+		d.Counts = make([]*float64, len(d.Values))
+		for j := range d.Counts {
+			d.Counts[j] = aws.Float64(2)
+		}
+		in.MetricData = append(in.MetricData, d)
+	}
+	before := in.GoString()
+	expected := &cloudwatch.PutMetricDataInput{}
+	awsutil.Copy(expected, in)
+
+	client := &memoryCloudWatchClient{}
+	p := &Pager{
+		Client: client,
+		Config: Config{
+			ClearInvalidUnits:        true,
+			DefaultDimensions:        []*cloudwatch.Dimension{{Name: aws.String("host"), Value: aws.String("b")}},
+			DefaultDimensionConflict: DefaultWins,
+			Stages: []Stage{
+				NamespaceMapper(func(ns string) string { return ns + "2" }),
+				LowerResolution,
+			},
+			Rollups:          []Rollup{{Dimensions: []string{"host"}}},
+			CardinalityGuard: &CardinalityGuard{MaxMetrics: 5, Action: CardinalityRewrite},
+		},
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := p.PutMetricData(in)
+			require.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			require.Equal(t, before, in.GoString())
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, expected, in)
+	require.Equal(t, before, in.GoString())
+	require.NotEmpty(t, client.in)
+}
+
+// contextClient records the context of each send, and builds and signs each request with its options like the SDK
+type contextClient struct {
+	memoryCloudWatchClient
+	ctxs []context.Context
+}
+
+func (c *contextClient) PutMetricDataWithContext(ctx aws.Context, in *cloudwatch.PutMetricDataInput, opts ...request.Option) (*cloudwatch.PutMetricDataOutput, error) {
+	req := reqWithBody(in.GoString())
+	req.ApplyOptions(opts...)
+	if err := req.Sign(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.ctxs = append(c.ctxs, ctx)
+	c.mu.Unlock()
+	return c.memoryCloudWatchClient.PutMetricDataWithContext(ctx, in, opts...)
+}
+
+type testContextKey struct{}
+
+func TestPager_PutMetricDataRequest(t *testing.T) {
+	input := &cloudwatch.PutMetricDataInput{
+		Namespace: aws.String("ns"),
+	}
+	for i := 0; i < 25; i++ {
+		input.MetricData = append(input.MetricData, &cloudwatch.MetricDatum{
+			MetricName: aws.String("m"),
+			Value:      aws.Float64(float64(i)),
+		})
+	}
+	t.Run("send", func(t *testing.T) {
+		client := &contextClient{}
+		p := &Pager{Client: client}
+		req, out := p.PutMetricDataRequest(input)
+		require.NotNil(t, out)
+		require.Equal(t, input, req.Params)
+		var validates, builds, signs, completes int32
+		req.Handlers.Validate.PushBack(func(*request.Request) { atomic.AddInt32(&validates, 1) })
+		req.Handlers.Build.PushBack(func(*request.Request) { atomic.AddInt32(&builds, 1) })
+		req.ApplyOptions(func(r *request.Request) {
+			r.Handlers.Sign.PushBack(func(*request.Request) { atomic.AddInt32(&signs, 1) })
+			r.Handlers.Complete.PushBack(func(*request.Request) { atomic.AddInt32(&completes, 1) })
+		})
+		req.SetContext(context.WithValue(context.Background(), testContextKey{}, "hello"))
+		require.NoError(t, req.Send())
+		require.Len(t, client.in, 2)
+		// Build and Sign handlers run on each underlying request
+		require.Equal(t, int32(1), validates)
+		require.Equal(t, int32(2), builds)
+		require.Equal(t, int32(2), signs)
+		require.Equal(t, int32(1), completes)
+		for _, ctx := range client.ctxs {
+			require.Equal(t, "hello", ctx.Value(testContextKey{}))
+		}
+	})
+	t.Run("params", func(t *testing.T) {
+		client := &memoryCloudWatchClient{}
+		p := &Pager{Client: client}
+		req, _ := p.PutMetricDataRequest(input)
+		req.Params = &cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String("other"),
+			MetricData: input.MetricData[:3],
+		}
+		require.NoError(t, req.Send())
+		require.Len(t, client.in, 1)
+		require.Equal(t, "other", *client.in[0].Namespace)
+		require.Len(t, client.in[0].MetricData, 3)
+	})
+	t.Run("error", func(t *testing.T) {
+		sendErr := errors.New("bad")
+		client := &memoryCloudWatchClient{errOnCall: 1, err: sendErr}
+		p := &Pager{Client: client, Config: Config{SerialSends: true}}
+		req, _ := p.PutMetricDataRequest(input)
+		require.Equal(t, sendErr, req.Send())
+		require.Equal(t, sendErr, req.Error)
+		require.Len(t, client.in, 2)
+	})
+	t.Run("nil", func(t *testing.T) {
+		p := &Pager{Client: &memoryCloudWatchClient{}}
+		req, out := p.PutMetricDataRequest(nil)
+		require.NotNil(t, out)
+		require.NoError(t, req.Send())
+	})
+}
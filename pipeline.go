@@ -0,0 +1,146 @@
+package cwpagedmetricput
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// Stage is one step of the pipeline Pager runs on every PutMetricDataInput before the datum are split and bucketed.
+type Stage interface {
+	// Process returns the input that should be sent in place of in.  Returning nil, or an input without any
+	// MetricData, sends nothing.  Implementations should not modify in or the datum it points to.
+	Process(in *cloudwatch.PutMetricDataInput) *cloudwatch.PutMetricDataInput
+}
+
+// Mapper is a Stage that replaces each datum with the datum it returns.  Returning an empty slice drops the datum.
+type Mapper func(datum *cloudwatch.MetricDatum) []*cloudwatch.MetricDatum
+
+var _ Stage = Mapper(nil)
+
+// Process runs the Mapper on each datum of in
+func (m Mapper) Process(in *cloudwatch.PutMetricDataInput) *cloudwatch.PutMetricDataInput {
+	if in == nil {
+		return nil
+	}
+	ret := *in
+	ret.MetricData = make([]*cloudwatch.MetricDatum, 0, len(in.MetricData))
+	for _, d := range in.MetricData {
+		ret.MetricData = append(ret.MetricData, m(d)...)
+	}
+	return &ret
+}
+
+// Filter is a Stage that keeps only the datum it returns true for
+type Filter func(datum *cloudwatch.MetricDatum) bool
+
+var _ Stage = Filter(nil)
+
+// Process removes datum of in that the Filter returns false for
+func (f Filter) Process(in *cloudwatch.PutMetricDataInput) *cloudwatch.PutMetricDataInput {
+	if in == nil {
+		return nil
+	}
+	ret := *in
+	ret.MetricData = make([]*cloudwatch.MetricDatum, 0, len(in.MetricData))
+	for _, d := range in.MetricData {
+		if f(d) {
+			ret.MetricData = append(ret.MetricData, d)
+		}
+	}
+	return &ret
+}
+
+// NamespaceMapper is a Stage that rewrites the namespace of the input
+type NamespaceMapper func(namespace string) string
+
+var _ Stage = NamespaceMapper(nil)
+
+// Process rewrites the namespace of in.  A nil namespace is left alone.
+func (n NamespaceMapper) Process(in *cloudwatch.PutMetricDataInput) *cloudwatch.PutMetricDataInput {
+	if in == nil || in.Namespace == nil {
+		return in
+	}
+	ret := *in
+	ns := n(*in.Namespace)
+	ret.Namespace = &ns
+	return &ret
+}
+
+// ClearInvalidUnitsStage is the built in Stage that Config.ClearInvalidUnits enables
+var ClearInvalidUnitsStage Stage = Mapper(func(datum *cloudwatch.MetricDatum) []*cloudwatch.MetricDatum {
+	if datum == nil {
+		return nil
+	}
+	return []*cloudwatch.MetricDatum{clearInvalidUnits(datum)}
+})
+
+// clearInvalidUnitsStage is ClearInvalidUnitsStage, but logs each unit it clears
+func (c *Config) clearInvalidUnitsStage() Stage {
+	logger := c.logger()
+	return Mapper(func(datum *cloudwatch.MetricDatum) []*cloudwatch.MetricDatum {
+		if datum == nil {
+			return nil
+		}
+		unit := datum.Unit
+		ret := clearInvalidUnits(datum)
+		if unit != nil && ret.Unit == nil {
+			logger.Log(LevelDebug, "cleared invalid unit", "metric_name", aws.StringValue(datum.MetricName), "unit", *unit)
+		}
+		return []*cloudwatch.MetricDatum{ret}
+	})
+}
+
+// stages returns every Stage the config wants executed, in order
+func (c *Config) stages() []Stage {
+	defaultDimensions := c.defaultDimensionsStage()
+	rollups := c.rollupStage()
+	if !c.ClearInvalidUnits && defaultDimensions == nil && rollups == nil && c.CardinalityGuard == nil {
+		return c.Stages
+	}
+	ret := make([]Stage, 0, len(c.Stages)+4)
+	if c.ClearInvalidUnits {
+		ret = append(ret, c.clearInvalidUnitsStage())
+	}
+	if defaultDimensions != nil {
+		ret = append(ret, defaultDimensions)
+	}
+	ret = append(ret, c.Stages...)
+	if rollups != nil {
+		ret = append(ret, rollups)
+	}
+	if c.CardinalityGuard != nil {
+		ret = append(ret, c.CardinalityGuard)
+	}
+	return ret
+}
+
+// selfMetricsStages are the only stages self metrics pass through.  Self metrics skip Stages, Rollups, and the
+// CardinalityGuard, so user rules can never drop the metrics that report on them.
+func (c *Config) selfMetricsStages() []Stage {
+	if defaultDimensions := c.defaultDimensionsStage(); defaultDimensions != nil {
+		return []Stage{defaultDimensions}
+	}
+	return nil
+}
+
+// runStages executes each configured Stage on in, in order
+func (c *Pager) runStages(in *cloudwatch.PutMetricDataInput) *cloudwatch.PutMetricDataInput {
+	return c.processStages(in, c.Config.stages(), DropReasonFiltered)
+}
+
+// processStages executes stages on in, in order, counting datum they remove as dropped for reason
+func (c *Pager) processStages(in *cloudwatch.PutMetricDataInput, stages []Stage, reason DropReason) *cloudwatch.PutMetricDataInput {
+	for _, s := range stages {
+		if in == nil {
+			return nil
+		}
+		stageReason := reason
+		if _, isGuard := s.(*CardinalityGuard); isGuard {
+			stageReason = DropReasonCardinality
+		}
+		before := len(in.MetricData)
+		in = s.Process(in)
+		c.countStageDrops(stageReason, before, in)
+	}
+	return in
+}
@@ -0,0 +1,94 @@
+package cwpagedmetricput
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapper_Process(t *testing.T) {
+	double := Mapper(func(d *cloudwatch.MetricDatum) []*cloudwatch.MetricDatum {
+		return []*cloudwatch.MetricDatum{d, d}
+	})
+	in := &cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String("ns"),
+		MetricData: []*cloudwatch.MetricDatum{{MetricName: aws.String("a")}},
+	}
+	out := double.Process(in)
+	require.Len(t, out.MetricData, 2)
+	require.Len(t, in.MetricData, 1)
+	require.Equal(t, "ns", *out.Namespace)
+	require.Nil(t, double.Process(nil))
+}
+
+func TestFilter_Process(t *testing.T) {
+	onlyA := Filter(func(d *cloudwatch.MetricDatum) bool {
+		return *d.MetricName == "a"
+	})
+	in := &cloudwatch.PutMetricDataInput{
+		MetricData: []*cloudwatch.MetricDatum{{MetricName: aws.String("a")}, {MetricName: aws.String("b")}},
+	}
+	out := onlyA.Process(in)
+	require.Len(t, out.MetricData, 1)
+	require.Equal(t, "a", *out.MetricData[0].MetricName)
+	require.Len(t, in.MetricData, 2)
+}
+
+func TestNamespaceMapper_Process(t *testing.T) {
+	upper := NamespaceMapper(func(ns string) string {
+		return ns + "/remapped"
+	})
+	in := &cloudwatch.PutMetricDataInput{Namespace: aws.String("ns")}
+	require.Equal(t, "ns/remapped", *upper.Process(in).Namespace)
+	require.Equal(t, "ns", *in.Namespace)
+	require.Nil(t, upper.Process(&cloudwatch.PutMetricDataInput{}).Namespace)
+}
+
+func TestClearInvalidUnitsStage_Process(t *testing.T) {
+	in := &cloudwatch.PutMetricDataInput{
+		MetricData: []*cloudwatch.MetricDatum{
+			{MetricName: aws.String("a"), Unit: aws.String("Second")},
+			{MetricName: aws.String("b"), Unit: aws.String(cloudwatch.StandardUnitSeconds)},
+		},
+	}
+	out := ClearInvalidUnitsStage.Process(in)
+	require.Nil(t, out.MetricData[0].Unit)
+	require.Equal(t, cloudwatch.StandardUnitSeconds, *out.MetricData[1].Unit)
+	require.Equal(t, "Second", *in.MetricData[0].Unit)
+}
+
+func TestPager_Stages(t *testing.T) {
+	client := &memoryCloudWatchClient{}
+	p := Pager{
+		Client: client,
+		Config: Config{
+			ClearInvalidUnits: true,
+			Stages: []Stage{
+				Filter(func(d *cloudwatch.MetricDatum) bool {
+					return *d.MetricName != "dropped"
+				}),
+			},
+		},
+	}
+	_, err := p.PutMetricData(&cloudwatch.PutMetricDataInput{
+		Namespace: aws.String("ns"),
+		MetricData: []*cloudwatch.MetricDatum{
+			{MetricName: aws.String("kept"), Value: aws.Float64(1), Unit: aws.String("bad")},
+			{MetricName: aws.String("dropped"), Value: aws.Float64(1)},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, client.in, 1)
+	require.Len(t, client.in[0].MetricData, 1)
+	require.Equal(t, "kept", *client.in[0].MetricData[0].MetricName)
+	require.Nil(t, client.in[0].MetricData[0].Unit)
+
+	_, err = p.PutMetricData(&cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String("ns"),
+		MetricData: []*cloudwatch.MetricDatum{{MetricName: aws.String("dropped"), Value: aws.Float64(1)}},
+	})
+	require.NoError(t, err)
+	require.Len(t, client.in, 1)
+}
@@ -0,0 +1,149 @@
+package cwpagedmetricput
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/corehandlers"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// PlannedRequest is a single request Plan found a PutMetricData call would send
+type PlannedRequest struct {
+	// Input is what would be passed to the Client
+	Input *cloudwatch.PutMetricDataInput
+	// BodySize is the size of the request body before encoding
+	BodySize int64
+	// EncodedSize is the size of the request body after encoding.  It is what counts against CloudWatch's size limit.
+	EncodedSize int64
+	// TooLarge is true if the request is too large to send even though it holds a single datum.  Pager drops it.
+	TooLarge bool
+}
+
+// Plan returns the requests PutMetricDataWithContext would send for input, without calling the Client.  Stages, value
+// splitting, bucketing, and bisection of requests that are too large all run exactly as they would for a real call.
+// Request bodies are built and encoded the way *cloudwatch.CloudWatch builds them, so sizes are accurate for it.
+// Plan does not change the Pager's Stats or Usage, and a CardinalityGuard only checks datum against what it has
+// already seen, without tracking them.
+func (c *Pager) Plan(input *cloudwatch.PutMetricDataInput) ([]PlannedRequest, error) {
+	if input == nil {
+		return nil, nil
+	}
+	input = c.planStages(input)
+	if input == nil || len(input.MetricData) == 0 {
+		return nil, nil
+	}
+	var ret []PlannedRequest
+	for _, bucket := range bucketDatum(splitDatum(input.MetricData)) {
+		planned, err := c.planDatum(input.Namespace, bucket)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, planned...)
+	}
+	return ret, nil
+}
+
+// planStages is runStages and applyBudget, but without counting drops, notifying the Budget, or tracking metrics in a
+// CardinalityGuard
+func (c *Pager) planStages(in *cloudwatch.PutMetricDataInput) *cloudwatch.PutMetricDataInput {
+	stages := c.Config.stages()
+	if b := c.Config.Budget; b != nil && b.exceeded(c.usage.snapshot(b.Period)) {
+		// stages may be Config.Stages itself, so never append into its backing array
+		stages = append(stages[:len(stages):len(stages)], b.Degrade...)
+	}
+	for _, s := range stages {
+		if in == nil {
+			return nil
+		}
+		if g, isGuard := s.(*CardinalityGuard); isGuard {
+			in = g.preview(in)
+			continue
+		}
+		in = s.Process(in)
+	}
+	return in
+}
+
+// planDatum plans sending datum in a single request, bisecting it like sendDatumOnce if the request is too large
+func (c *Pager) planDatum(namespace *string, datum []*cloudwatch.MetricDatum) ([]PlannedRequest, error) {
+	if len(datum) == 0 {
+		return nil, nil
+	}
+	planned := PlannedRequest{
+		Input: &cloudwatch.PutMetricDataInput{
+			MetricData: datum,
+			Namespace:  namespace,
+		},
+	}
+	req, _ := planClient().PutMetricDataRequest(planned.Input)
+	req.ApplyOptions(encodeBodyOption(c.encoder(), putMetricDataKBRequestSizeLimit, func(before int64, after int64) {
+		planned.BodySize = before
+		planned.EncodedSize = after
+	}))
+	err := req.Build()
+	if err == nil {
+		return []PlannedRequest{planned}, nil
+	}
+	if !IsRequestSizeError(err) {
+		return nil, err
+	}
+	if len(datum) == 1 {
+		planned.TooLarge = true
+		return []PlannedRequest{planned}, nil
+	}
+	mid := len(datum) / 2
+	first, err := c.planDatum(namespace, datum[0:mid])
+	if err != nil {
+		return nil, err
+	}
+	second, err := c.planDatum(namespace, datum[mid:])
+	if err != nil {
+		return nil, err
+	}
+	return append(first, second...), nil
+}
+
+// planConfigProvider configures a CloudWatch client that only ever builds requests
+type planConfigProvider struct{}
+
+func (planConfigProvider) ClientConfig(serviceName string, cfgs ...*aws.Config) client.Config {
+	handlers := request.Handlers{}
+	handlers.Validate.PushBackNamed(corehandlers.ValidateParametersHandler)
+	cfg := aws.NewConfig().WithRegion("us-east-1").WithCredentials(credentials.AnonymousCredentials)
+	cfg.MergeIn(cfgs...)
+	return client.Config{
+		Config:        cfg,
+		Handlers:      handlers,
+		Endpoint:      "https://monitoring.us-east-1.amazonaws.com",
+		SigningRegion: "us-east-1",
+		SigningName:   serviceName,
+	}
+}
+
+var planClientOnce sync.Once
+var planClientInstance *cloudwatch.CloudWatch
+
+// planClient returns the CloudWatch client Plan builds request bodies with.  It never sends anything.
+func planClient() *cloudwatch.CloudWatch {
+	planClientOnce.Do(func() {
+		planClientInstance = cloudwatch.New(planConfigProvider{})
+	})
+	return planClientInstance
+}
+
+// dryRun logs the requests Plan finds input would send
+func (c *Pager) dryRun(input *cloudwatch.PutMetricDataInput) error {
+	planned, err := c.Plan(input)
+	if err != nil {
+		return err
+	}
+	logger := c.Config.logger()
+	for _, p := range planned {
+		logger.Log(LevelInfo, "dry run request", "namespace", aws.StringValue(p.Input.Namespace), "datum_count", len(p.Input.MetricData), "body_size", p.BodySize, "encoded_size", p.EncodedSize, "too_large", p.TooLarge)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package cwpagedmetricput
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+// paddingEncoder makes every body larger than CloudWatch's limit
+type paddingEncoder struct{}
+
+func (paddingEncoder) ContentEncoding() string {
+	return ""
+}
+
+func (paddingEncoder) Encode(w io.Writer, body io.Reader) error {
+	if _, err := io.Copy(w, body); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, strings.Repeat("x", putMetricDataKBRequestSizeLimit))
+	return err
+}
+
+func planInput(count int) *cloudwatch.PutMetricDataInput {
+	ret := &cloudwatch.PutMetricDataInput{
+		Namespace: aws.String("ns"),
+	}
+	for i := 0; i < count; i++ {
+		ret.MetricData = append(ret.MetricData, &cloudwatch.MetricDatum{
+			MetricName: aws.String(fmt.Sprintf("metric%d", i)),
+			Value:      aws.Float64(float64(i)),
+		})
+	}
+	return ret
+}
+
+func TestPager_Plan(t *testing.T) {
+	t.Run("nil", func(t *testing.T) {
+		p := &Pager{}
+		planned, err := p.Plan(nil)
+		require.NoError(t, err)
+		require.Empty(t, planned)
+	})
+	t.Run("buckets", func(t *testing.T) {
+		// No Client is needed, since nothing is sent
+		p := &Pager{}
+		planned, err := p.Plan(planInput(45))
+		require.NoError(t, err)
+		require.Len(t, planned, 3)
+		require.Len(t, planned[0].Input.MetricData, 20)
+		require.Len(t, planned[2].Input.MetricData, 5)
+		for _, r := range planned {
+			require.Equal(t, "ns", *r.Input.Namespace)
+			require.False(t, r.TooLarge)
+			require.True(t, r.BodySize > r.EncodedSize)
+			require.True(t, r.EncodedSize > 0)
+		}
+		require.Equal(t, int64(0), p.Stats().Calls)
+		require.Equal(t, int64(0), p.Usage().Requests)
+	})
+	t.Run("uncompressed", func(t *testing.T) {
+		p := &Pager{Config: Config{DisableCompression: true}}
+		planned, err := p.Plan(planInput(1))
+		require.NoError(t, err)
+		require.Len(t, planned, 1)
+		require.Equal(t, planned[0].BodySize, planned[0].EncodedSize)
+	})
+	t.Run("split values and clear units", func(t *testing.T) {
+		in := &cloudwatch.PutMetricDataInput{
+			Namespace: aws.String("ns"),
+			MetricData: []*cloudwatch.MetricDatum{
+				{
+					MetricName: aws.String("m"),
+					Unit:       aws.String("invalid"),
+					Values:     make([]*float64, 400),
+				},
+			},
+		}
+		for i := range in.MetricData[0].Values {
+			in.MetricData[0].Values[i] = aws.Float64(float64(i))
+		}
+		p := &Pager{Config: Config{ClearInvalidUnits: true}}
+		planned, err := p.Plan(in)
+		require.NoError(t, err)
+		require.Len(t, planned, 1)
+		require.Len(t, planned[0].Input.MetricData, 3)
+		for _, d := range planned[0].Input.MetricData {
+			require.Nil(t, d.Unit)
+		}
+	})
+	t.Run("filtered", func(t *testing.T) {
+		p := &Pager{Config: Config{Stages: []Stage{Filter(func(*cloudwatch.MetricDatum) bool { return false })}}}
+		planned, err := p.Plan(planInput(3))
+		require.NoError(t, err)
+		require.Empty(t, planned)
+		require.Equal(t, int64(0), p.Stats().Dropped[DropReasonFiltered])
+	})
+	t.Run("cardinality guard", func(t *testing.T) {
+		guard := &CardinalityGuard{MaxMetrics: 2}
+		p := &Pager{Config: Config{CardinalityGuard: guard}}
+		for i := 0; i < 2; i++ {
+			planned, err := p.Plan(planInput(3))
+			require.NoError(t, err)
+			require.Len(t, planned, 1)
+			require.Len(t, planned[0].Input.MetricData, 2)
+		}
+		require.Empty(t, guard.namespaces)
+	})
+	t.Run("budget degrade", func(t *testing.T) {
+		stages := make([]Stage, 1, 2)
+		stages[0] = Filter(func(*cloudwatch.MetricDatum) bool { return true })
+		drop := Filter(func(*cloudwatch.MetricDatum) bool { return false })
+		p := &Pager{Config: Config{Stages: stages, Budget: &Budget{MaxRequests: 1, Degrade: []Stage{drop}}}}
+		p.usage.addRequest(p.Config.usagePeriod())
+		p.usage.addRequest(p.Config.usagePeriod())
+		planned, err := p.Plan(planInput(3))
+		require.NoError(t, err)
+		require.Empty(t, planned)
+		// The caller's slice has room for Degrade, but is never written to
+		require.Nil(t, stages[:2][1])
+	})
+	t.Run("bisects", func(t *testing.T) {
+		p := &Pager{Config: Config{Encoder: paddingEncoder{}}}
+		planned, err := p.Plan(planInput(3))
+		require.NoError(t, err)
+		require.Len(t, planned, 3)
+		for _, r := range planned {
+			require.Len(t, r.Input.MetricData, 1)
+			require.True(t, r.TooLarge)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		p := &Pager{}
+		_, err := p.Plan(&cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String("ns"),
+			MetricData: []*cloudwatch.MetricDatum{{Value: aws.Float64(1)}},
+		})
+		require.Error(t, err)
+	})
+}
+
+func TestPager_DryRun(t *testing.T) {
+	client := &memoryCloudWatchClient{}
+	logger := &testLogger{}
+	p := &Pager{Client: client, Config: Config{DryRun: true, Logger: logger}}
+	_, err := p.PutMetricData(planInput(25))
+	require.NoError(t, err)
+	require.Empty(t, client.in)
+	require.Len(t, logger.events, 2)
+	require.Contains(t, logger.events[0], "dry run request")
+}
@@ -0,0 +1,262 @@
+package promscrape
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// LabelRule allows some labels of some metrics to become dimensions
+type LabelRule struct {
+	// Metric matches the family names the rule applies to.  nil matches every metric.
+	Metric *regexp.Regexp
+	// Labels are the label names that become dimensions
+	Labels []string
+}
+
+// unitSuffixes infer a CloudWatch unit from the base unit suffix Prometheus names use
+var unitSuffixes = []struct {
+	suffix string
+	unit   string
+}{
+	{"_seconds", cloudwatch.StandardUnitSeconds},
+	{"_bytes", cloudwatch.StandardUnitBytes},
+}
+
+func unitOf(name string) *string {
+	name = strings.TrimSuffix(name, "_total")
+	for _, u := range unitSuffixes {
+		if strings.HasSuffix(name, u.suffix) {
+			return aws.String(u.unit)
+		}
+	}
+	return nil
+}
+
+// converter turns one scrape of a target into datum.  Counters are cumulative, so it needs the values of the
+// previous scrape to send deltas.
+type converter struct {
+	rules      []LabelRule
+	dimensions []*cloudwatch.Dimension
+	now        time.Time
+	// previous are the cumulative values of the last scrape, by seriesKey
+	previous map[string]float64
+	// next collects the cumulative values of this scrape
+	next map[string]float64
+}
+
+// seriesKey identifies a sample by its name and every label, before any are dropped
+func seriesKey(s *Sample) string {
+	parts := make([]string, 0, len(s.Labels))
+	for _, l := range s.Labels {
+		parts = append(parts, l.Name+"="+l.Value)
+	}
+	sort.Strings(parts)
+	return s.Name + "\x00" + strings.Join(parts, "\x00")
+}
+
+// delta records a cumulative value and returns how much it grew since the last scrape.  It returns false the first
+// time a series is seen.  A value lower than last time means the counter reset, so all of it is new.
+func (c *converter) delta(s *Sample) (float64, bool) {
+	key := seriesKey(s)
+	c.next[key] = s.Value
+	last, exists := c.previous[key]
+	if !exists {
+		return 0, false
+	}
+	if s.Value < last {
+		return s.Value, true
+	}
+	return s.Value - last, true
+}
+
+// allowed returns true if label may be a dimension of the family called name
+func (c *converter) allowed(name string, label string) bool {
+	for _, rule := range c.rules {
+		if rule.Metric != nil && !rule.Metric.MatchString(name) {
+			continue
+		}
+		for _, l := range rule.Labels {
+			if l == label {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// datum returns a datum for a sample of family f, with its allowed labels as dimensions
+func (c *converter) datum(f *Family, metricName string, s *Sample, keep string) *cloudwatch.MetricDatum {
+	timestamp := c.now
+	if s.Timestamp != 0 {
+		timestamp = time.Unix(0, s.Timestamp*int64(time.Millisecond))
+	}
+	ret := &cloudwatch.MetricDatum{
+		MetricName: aws.String(metricName),
+		Timestamp:  aws.Time(timestamp),
+		Unit:       unitOf(f.Name),
+		Dimensions: append([]*cloudwatch.Dimension(nil), c.dimensions...),
+	}
+	for _, l := range s.Labels {
+		if l.Name == keep || (l.Name != "le" && c.allowed(f.Name, l.Name)) {
+			ret.Dimensions = append(ret.Dimensions, &cloudwatch.Dimension{
+				Name:  aws.String(l.Name),
+				Value: aws.String(l.Value),
+			})
+		}
+	}
+	return ret
+}
+
+func validValue(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// convert returns the datum of every family
+func (c *converter) convert(families []*Family) []*cloudwatch.MetricDatum {
+	var ret []*cloudwatch.MetricDatum
+	for _, f := range families {
+		switch f.Type {
+		case TypeHistogram:
+			ret = append(ret, c.histogram(f)...)
+		case TypeCounter:
+			ret = append(ret, c.counter(f, f.Samples)...)
+		case TypeSummary:
+			ret = append(ret, c.summary(f)...)
+		default:
+			ret = append(ret, c.gauge(f, f.Samples, "")...)
+		}
+	}
+	return ret
+}
+
+// counter sends the delta of each sample since the last scrape
+func (c *converter) counter(f *Family, samples []Sample) []*cloudwatch.MetricDatum {
+	var ret []*cloudwatch.MetricDatum
+	for i := range samples {
+		s := &samples[i]
+		if !validValue(s.Value) {
+			continue
+		}
+		d, ok := c.delta(s)
+		if !ok {
+			continue
+		}
+		datum := c.datum(f, s.Name, s, "")
+		datum.Value = aws.Float64(d)
+		ret = append(ret, datum)
+	}
+	return ret
+}
+
+// gauge sends each sample as it is.  The label called keep is always a dimension.
+func (c *converter) gauge(f *Family, samples []Sample, keep string) []*cloudwatch.MetricDatum {
+	var ret []*cloudwatch.MetricDatum
+	for i := range samples {
+		s := &samples[i]
+		if !validValue(s.Value) {
+			continue
+		}
+		datum := c.datum(f, s.Name, s, keep)
+		datum.Value = aws.Float64(s.Value)
+		ret = append(ret, datum)
+	}
+	return ret
+}
+
+// summary sends quantiles as gauges with a quantile dimension, and _sum and _count as counters
+func (c *converter) summary(f *Family) []*cloudwatch.MetricDatum {
+	var quantiles, totals []Sample
+	for _, s := range f.Samples {
+		if s.Name == f.Name {
+			quantiles = append(quantiles, s)
+		} else {
+			totals = append(totals, s)
+		}
+	}
+	return append(c.gauge(f, quantiles, "quantile"), c.counter(f, totals)...)
+}
+
+// bucket is one cumulative bucket of a histogram
+type bucket struct {
+	le     float64
+	sample *Sample
+}
+
+// histogram sends the buckets filled since the last scrape as Values and Counts.  Each bucket is represented by its
+// upper bound, and the +Inf bucket by the largest finite bound.
+func (c *converter) histogram(f *Family) []*cloudwatch.MetricDatum {
+	groups := make(map[string][]bucket)
+	var order []string
+	for i := range f.Samples {
+		s := &f.Samples[i]
+		if s.Name != f.Name+"_bucket" {
+			continue
+		}
+		leValue, _ := s.label("le")
+		le, err := strconv.ParseFloat(leValue, 64)
+		if err != nil {
+			continue
+		}
+		key := seriesKey(&Sample{Name: s.Name, Labels: withoutLabel(s.Labels, "le")})
+		if _, exists := groups[key]; !exists {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], bucket{le: le, sample: s})
+	}
+	var ret []*cloudwatch.MetricDatum
+	for _, key := range order {
+		if datum := c.histogramDatum(f, groups[key]); datum != nil {
+			ret = append(ret, datum)
+		}
+	}
+	return ret
+}
+
+func (c *converter) histogramDatum(f *Family, buckets []bucket) *cloudwatch.MetricDatum {
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].le < buckets[j].le })
+	var values, counts []*float64
+	// Every bucket needs a previous scrape, or the counts would be wrong
+	complete := true
+	lastCumulative := 0.0
+	bound := 0.0
+	for _, b := range buckets {
+		cumulative, ok := c.delta(b.sample)
+		if !ok {
+			complete = false
+			continue
+		}
+		if !math.IsInf(b.le, 1) {
+			bound = b.le
+		}
+		count := cumulative - lastCumulative
+		lastCumulative = cumulative
+		if count > 0 {
+			values = append(values, aws.Float64(bound))
+			counts = append(counts, aws.Float64(count))
+		}
+	}
+	if !complete || len(values) == 0 {
+		return nil
+	}
+	ret := c.datum(f, f.Name, buckets[0].sample, "")
+	ret.Values = values
+	ret.Counts = counts
+	return ret
+}
+
+func withoutLabel(labels []Label, name string) []Label {
+	ret := make([]Label, 0, len(labels))
+	for _, l := range labels {
+		if l.Name != name {
+			ret = append(ret, l)
+		}
+	}
+	return ret
+}
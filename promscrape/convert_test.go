@@ -0,0 +1,84 @@
+package promscrape
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParse(t *testing.T, text string) []*Family {
+	families, err := Parse(strings.NewReader(text))
+	require.NoError(t, err)
+	return families
+}
+
+// convertTwice converts first, then second, as two scrapes of the same target, and returns the datum of the second
+func convertTwice(t *testing.T, rules []LabelRule, first string, second string) []*cloudwatch.MetricDatum {
+	c := converter{rules: rules, now: time.Unix(1000, 0), next: make(map[string]float64)}
+	c.convert(mustParse(t, first))
+	c = converter{rules: rules, now: time.Unix(1000, 0), previous: c.next, next: make(map[string]float64)}
+	return c.convert(mustParse(t, second))
+}
+
+func Test_converter(t *testing.T) {
+	t.Run("counter", func(t *testing.T) {
+		text := "# TYPE requests_total counter\nrequests_total{code=\"200\",pod=\"a\"} %s\n"
+		first := converter{now: time.Unix(1000, 0), next: make(map[string]float64)}
+		require.Empty(t, first.convert(mustParse(t, strings.Replace(text, "%s", "10", 1))))
+
+		rules := []LabelRule{{Metric: regexp.MustCompile("^requests"), Labels: []string{"code"}}}
+		got := convertTwice(t, rules, strings.Replace(text, "%s", "10", 1), strings.Replace(text, "%s", "15", 1))
+		require.Len(t, got, 1)
+		require.Equal(t, 5.0, *got[0].Value)
+		require.Equal(t, []*cloudwatch.Dimension{{Name: aws.String("code"), Value: aws.String("200")}}, got[0].Dimensions)
+
+		// A reset counts everything since the reset
+		got = convertTwice(t, nil, strings.Replace(text, "%s", "10", 1), strings.Replace(text, "%s", "3", 1))
+		require.Equal(t, 3.0, *got[0].Value)
+		require.Empty(t, got[0].Dimensions)
+	})
+	t.Run("gauge", func(t *testing.T) {
+		got := convertTwice(t, nil, "", "# TYPE size_bytes gauge\nsize_bytes 7\nsize_bytes{a=\"b\"} NaN\n")
+		require.Len(t, got, 1)
+		require.Equal(t, 7.0, *got[0].Value)
+		require.Equal(t, cloudwatch.StandardUnitBytes, *got[0].Unit)
+	})
+	t.Run("histogram", func(t *testing.T) {
+		first := `# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 3
+latency_seconds_bucket{le="+Inf"} 4
+latency_seconds_count 4
+`
+		second := `# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 3
+latency_seconds_bucket{le="1"} 5
+latency_seconds_bucket{le="+Inf"} 7
+latency_seconds_count 7
+`
+		got := convertTwice(t, nil, first, second)
+		require.Len(t, got, 1)
+		require.Equal(t, "latency_seconds", *got[0].MetricName)
+		require.Equal(t, cloudwatch.StandardUnitSeconds, *got[0].Unit)
+		require.Equal(t, []*float64{aws.Float64(0.1), aws.Float64(1)}, got[0].Values)
+		require.Equal(t, []*float64{aws.Float64(2), aws.Float64(1)}, got[0].Counts)
+		require.Empty(t, got[0].Dimensions)
+
+		// Nothing new, so nothing sent
+		require.Empty(t, convertTwice(t, nil, first, first))
+	})
+	t.Run("summary", func(t *testing.T) {
+		text := "# TYPE rpc summary\nrpc{quantile=\"0.5\"} 0.2\nrpc_count %s\n"
+		got := convertTwice(t, nil, strings.Replace(text, "%s", "1", 1), strings.Replace(text, "%s", "4", 1))
+		require.Len(t, got, 2)
+		require.Equal(t, "rpc", *got[0].MetricName)
+		require.Equal(t, "quantile", *got[0].Dimensions[0].Name)
+		require.Equal(t, "rpc_count", *got[1].MetricName)
+		require.Equal(t, 3.0, *got[1].Value)
+	})
+}
@@ -0,0 +1,196 @@
+package promscrape
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The metric types of the Prometheus text format
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+	TypeSummary   = "summary"
+	TypeUntyped   = "untyped"
+)
+
+// Label is a single name="value" pair of a sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a single line of the text format
+type Sample struct {
+	// Name is the name of the line, which for histograms and summaries has a suffix like _bucket
+	Name   string
+	Labels []Label
+	Value  float64
+	// Timestamp is in milliseconds, or zero if the line has none
+	Timestamp int64
+}
+
+// label returns the value of the label called name, and if it exists
+func (s *Sample) label(name string) (string, bool) {
+	for _, l := range s.Labels {
+		if l.Name == name {
+			return l.Value, true
+		}
+	}
+	return "", false
+}
+
+// Family is the samples of one metric, grouped by its # TYPE line
+type Family struct {
+	Name    string
+	Type    string
+	Samples []Sample
+}
+
+// familySuffixes are the suffixes of samples that belong to a histogram or summary family
+var familySuffixes = []string{"_bucket", "_sum", "_count"}
+
+// Parse reads the Prometheus text exposition format.  Families are returned in the order they first appear.  Samples
+// without a # TYPE line are untyped.
+func Parse(r io.Reader) ([]*Family, error) {
+	types := make(map[string]string)
+	byName := make(map[string]*Family)
+	var ret []*Family
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+		sample, err := parseSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("promscrape: line %d: %s", lineNumber, err)
+		}
+		name, typ := familyOf(sample.Name, types)
+		f, exists := byName[name]
+		if !exists {
+			f = &Family{Name: name, Type: typ}
+			byName[name] = f
+			ret = append(ret, f)
+		}
+		f.Samples = append(f.Samples, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// familyOf returns the family name and type of a sample name
+func familyOf(name string, types map[string]string) (string, string) {
+	if typ, exists := types[name]; exists {
+		return name, typ
+	}
+	for _, suffix := range familySuffixes {
+		base := strings.TrimSuffix(name, suffix)
+		if base == name {
+			continue
+		}
+		if typ := types[base]; typ == TypeHistogram || typ == TypeSummary {
+			return base, typ
+		}
+	}
+	return name, TypeUntyped
+}
+
+// parseSample parses a line like `name{label="value"} 1 1000`
+func parseSample(line string) (Sample, error) {
+	var ret Sample
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return ret, fmt.Errorf("sample %q has no value", line)
+	}
+	ret.Name = line[:end]
+	rest := line[end:]
+	if rest[0] == '{' {
+		var err error
+		if ret.Labels, rest, err = parseLabels(rest[1:]); err != nil {
+			return ret, err
+		}
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return ret, fmt.Errorf("sample %q has no value", line)
+	}
+	var err error
+	if ret.Value, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return ret, fmt.Errorf("invalid value %q", fields[0])
+	}
+	if len(fields) == 2 {
+		if ret.Timestamp, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+			return ret, fmt.Errorf("invalid timestamp %q", fields[1])
+		}
+	}
+	return ret, nil
+}
+
+// parseLabels parses labels up to and including the closing brace, returning what follows it
+func parseLabels(s string) ([]Label, string, error) {
+	var ret []Label
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return nil, "", fmt.Errorf("unterminated labels")
+		}
+		if s[0] == '}' {
+			return ret, s[1:], nil
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, "", fmt.Errorf("invalid label %q", s)
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		if s == "" || s[0] != '"' {
+			return nil, "", fmt.Errorf("label %s has no quoted value", name)
+		}
+		value, rest, err := parseQuoted(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		ret = append(ret, Label{Name: name, Value: value})
+		s = rest
+	}
+}
+
+// parseQuoted reads an escaped label value up to its closing quote, returning what follows the quote
+func parseQuoted(s string) (string, string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), s[i+1:], nil
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", "", fmt.Errorf("unterminated label value")
+			}
+			if s[i] == 'n' {
+				b.WriteByte('\n')
+			} else {
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", "", fmt.Errorf("unterminated label value")
+}
@@ -0,0 +1,67 @@
+package promscrape
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const exposition = `# HELP http_requests_total Total requests.
+# TYPE http_requests_total counter
+http_requests_total{method="get",code="200"} 10
+http_requests_total{method="post",code="500"} 2 1000
+# TYPE temperature gauge
+temperature 21.5
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 3
+latency_seconds_bucket{le="+Inf"} 4
+latency_seconds_sum 5.5
+latency_seconds_count 4
+# TYPE rpc summary
+rpc{quantile="0.5"} 0.2
+rpc_sum 10
+rpc_count 50
+escaped{path="a \"b\"\\c\nd"} NaN
+`
+
+func TestParse(t *testing.T) {
+	families, err := Parse(strings.NewReader(exposition))
+	require.NoError(t, err)
+	require.Len(t, families, 5)
+
+	require.Equal(t, "http_requests_total", families[0].Name)
+	require.Equal(t, TypeCounter, families[0].Type)
+	require.Equal(t, []Sample{
+		{Name: "http_requests_total", Labels: []Label{{"method", "get"}, {"code", "200"}}, Value: 10},
+		{Name: "http_requests_total", Labels: []Label{{"method", "post"}, {"code", "500"}}, Value: 2, Timestamp: 1000},
+	}, families[0].Samples)
+
+	require.Equal(t, TypeGauge, families[1].Type)
+	require.Equal(t, "latency_seconds", families[2].Name)
+	require.Equal(t, TypeHistogram, families[2].Type)
+	require.Len(t, families[2].Samples, 5)
+	require.Equal(t, TypeSummary, families[3].Type)
+	require.Len(t, families[3].Samples, 3)
+
+	require.Equal(t, TypeUntyped, families[4].Type)
+	require.Equal(t, `a "b"\c`+"\nd", families[4].Samples[0].Labels[0].Value)
+	require.True(t, math.IsNaN(families[4].Samples[0].Value))
+}
+
+func TestParse_errors(t *testing.T) {
+	for _, text := range []string{
+		"novalue",
+		"bad_value abc",
+		`unterminated{a="b`,
+		`unquoted{a=b} 1`,
+		"bad_timestamp 1 abc",
+	} {
+		t.Run(text, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(text))
+			require.Error(t, err)
+		})
+	}
+}
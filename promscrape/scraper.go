@@ -0,0 +1,153 @@
+/*
+Package promscrape scrapes targets that expose the Prometheus text format and publishes their metrics to CloudWatch
+through a cwpagedmetricput.Pager.
+*/
+package promscrape
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/cep21/cwpagedmetricput"
+	"github.com/cep21/cwpagedmetricput/internal/defaults"
+)
+
+const defaultInterval = time.Minute
+
+// Target is an endpoint to scrape
+type Target struct {
+	// URL of the metrics, like http://localhost:9100/metrics
+	URL string
+	// Dimensions are added to every metric of the target, for example to tell hosts apart
+	Dimensions []*cloudwatch.Dimension
+}
+
+// Scraper periodically scrapes Targets.  Counters are sent as the delta since the last scrape, so the first scrape of
+// a counter sends nothing.  Delivery is at most once: counter values are remembered whether or not publishing them
+// succeeds, since a Pager sends buckets independently and cannot report which were published.  Gauges and untyped
+// metrics are sent as they are.  Histogram buckets are sent as Values and
+// Counts, and summaries as a gauge per quantile plus _sum and _count counters.
+//
+// Labels only become dimensions when a LabelRule allows them.  Series that differ only by dropped labels are sent as
+// separate samples of the same metric, so sums stay correct.
+type Scraper struct {
+	// Client receives the metrics.  It is required and is usually a *cwpagedmetricput.Pager.
+	Client cwpagedmetricput.CloudWatchClient
+	// Namespace of every metric.  It is required.
+	Namespace string
+	Targets   []Target
+	// LabelRules select the labels that become dimensions.  With none, no labels do.
+	LabelRules []LabelRule
+	// Interval between scrapes.  Defaults to one minute.
+	Interval time.Duration
+	// HTTPClient fetches targets.  Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// Logger receives scrape and publish errors from Run.  Defaults to cwpagedmetricput.NopLogger.
+	Logger cwpagedmetricput.Logger
+
+	mu sync.Mutex
+	// previous are the cumulative values of the last scrape of each target URL
+	previous map[string]map[string]float64
+	// now is time.Now, but can be replaced for testing
+	now func() time.Time
+}
+
+func (s *Scraper) interval() time.Duration {
+	if s.Interval <= 0 {
+		return defaultInterval
+	}
+	return s.Interval
+}
+
+func (s *Scraper) httpClient() *http.Client {
+	if s.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return s.HTTPClient
+}
+
+// Run scrapes every Interval until ctx ends, logging errors, then returns ctx.Err()
+func (s *Scraper) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval())
+	defer ticker.Stop()
+	for {
+		if err := s.Scrape(ctx); err != nil {
+			defaults.Logger(s.Logger).Log(cwpagedmetricput.LevelWarn, "unable to scrape prometheus metrics", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scrape fetches every target once and publishes their metrics in a single call to Client.  A target that fails does
+// not stop the others.  It returns the first error.
+func (s *Scraper) Scrape(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.previous == nil {
+		s.previous = make(map[string]map[string]float64)
+	}
+	var firstErr error
+	var datum []*cloudwatch.MetricDatum
+	next := make(map[string]map[string]float64, len(s.Targets))
+	for _, target := range s.Targets {
+		families, err := s.fetch(ctx, target.URL)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		c := converter{
+			rules:      s.LabelRules,
+			dimensions: target.Dimensions,
+			now:        defaults.Now(s.now),
+			previous:   s.previous[target.URL],
+			next:       make(map[string]float64),
+		}
+		datum = append(datum, c.convert(families)...)
+		next[target.URL] = c.next
+	}
+	if len(datum) != 0 {
+		if _, err := s.Client.PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(s.Namespace),
+			MetricData: datum,
+		}); err != nil && firstErr == nil {
+			// Some buckets may have been published, so advance anyway rather than send their deltas twice
+			firstErr = err
+		}
+	}
+	// Series missing from this scrape are forgotten
+	for url, values := range next {
+		s.previous[url] = values
+	}
+	return firstErr
+}
+
+func (s *Scraper) fetch(ctx context.Context, url string) ([]*Family, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+	resp, err := s.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("promscrape: %s returned status %d", url, resp.StatusCode)
+	}
+	return Parse(resp.Body)
+}
@@ -0,0 +1,81 @@
+package promscrape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/cep21/cwpagedmetricput/internal/cwtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScraper_Scrape(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requests++
+		_, _ = fmt.Fprintf(rw, "# TYPE requests_total counter\nrequests_total %d\n# TYPE up gauge\nup 1\n", requests*10)
+	}))
+	defer server.Close()
+	broken := httptest.NewServer(http.NotFoundHandler())
+	defer broken.Close()
+
+	client := &cwtest.Client{}
+	s := &Scraper{
+		Client:    client,
+		Namespace: "ns",
+		Targets: []Target{
+			{URL: server.URL, Dimensions: []*cloudwatch.Dimension{{Name: aws.String("host"), Value: aws.String("a")}}},
+			{URL: broken.URL},
+		},
+	}
+	require.Error(t, s.Scrape(context.Background()))
+	require.Len(t, client.Inputs(), 1)
+	require.Equal(t, "ns", *client.Inputs()[0].Namespace)
+	// Only the gauge: the counter has no previous scrape
+	require.Len(t, client.Inputs()[0].MetricData, 1)
+	require.Equal(t, "up", *client.Inputs()[0].MetricData[0].MetricName)
+	require.Equal(t, "host", *client.Inputs()[0].MetricData[0].Dimensions[0].Name)
+
+	require.Error(t, s.Scrape(context.Background()))
+	require.Len(t, client.Inputs(), 2)
+	require.Len(t, client.Inputs()[1].MetricData, 2)
+	require.Equal(t, "requests_total", *client.Inputs()[1].MetricData[0].MetricName)
+	require.Equal(t, 10.0, *client.Inputs()[1].MetricData[0].Value)
+}
+
+func TestScraper_Scrape_publishFails(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requests++
+		_, _ = fmt.Fprintf(rw, "# TYPE requests_total counter\nrequests_total %d\n", requests*10)
+	}))
+	defer server.Close()
+
+	client := &cwtest.Client{}
+	s := &Scraper{Client: client, Namespace: "ns", Targets: []Target{{URL: server.URL}}}
+	require.NoError(t, s.Scrape(context.Background()))
+	client.SetErr(errors.New("unavailable"))
+	require.Error(t, s.Scrape(context.Background()))
+	client.SetErr(nil)
+	// Delivery is at most once: the failed delta is dropped rather than risk sending it twice
+	require.NoError(t, s.Scrape(context.Background()))
+	require.Len(t, client.Inputs(), 1)
+	require.Equal(t, 10.0, *client.Inputs()[0].MetricData[0].Value)
+}
+
+func TestScraper_Run(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = fmt.Fprint(rw, "up 1\n")
+	}))
+	defer server.Close()
+	client := &cwtest.Client{}
+	s := &Scraper{Client: client, Namespace: "ns", Targets: []Target{{URL: server.URL}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Equal(t, context.Canceled, s.Run(ctx))
+}
@@ -0,0 +1,209 @@
+package cwpagedmetricput
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// Rollup describes an extra, aggregated copy of every datum that Pager publishes.  CloudWatch does not aggregate
+// across dimensions, so a metric that should be graphed per host and per service has to be published once for each.
+type Rollup struct {
+	// Dimensions are the names of the dimensions kept on the rolled up datum.  Every other dimension is removed.  An
+	// empty list rolls up to a datum without any dimensions.  Datum missing any of these dimensions are not rolled up.
+	Dimensions []string
+}
+
+// rollupStage emits, for each Rollup, the aggregated datum of the input along with the original datum.  Rolled up
+// datum that share a metric name, dimensions, timestamp, unit, and storage resolution are merged into a single datum
+// when possible, so rollups don't multiply the number of datum sent.
+type rollupStage struct {
+	rollups []Rollup
+}
+
+var _ Stage = &rollupStage{}
+
+// rollupStage returns the Stage that applies the config's Rollups, or nil if there are none
+func (c *Config) rollupStage() Stage {
+	if len(c.Rollups) == 0 {
+		return nil
+	}
+	return &rollupStage{rollups: c.Rollups}
+}
+
+// Process returns in with the rolled up datum appended
+func (r *rollupStage) Process(in *cloudwatch.PutMetricDataInput) *cloudwatch.PutMetricDataInput {
+	if in == nil {
+		return nil
+	}
+	groups := make(map[string]*rollupGroup)
+	order := make([]*rollupGroup, 0, len(in.MetricData))
+	for _, rollup := range r.rollups {
+		for _, d := range in.MetricData {
+			rolled := rollupDatum(d, rollup.Dimensions)
+			if rolled == nil {
+				continue
+			}
+			k := rollupKey(rolled)
+			g, exists := groups[k]
+			if !exists {
+				g = &rollupGroup{}
+				groups[k] = g
+				order = append(order, g)
+			}
+			g.datum = append(g.datum, rolled)
+		}
+	}
+	ret := *in
+	ret.MetricData = make([]*cloudwatch.MetricDatum, 0, len(in.MetricData)+len(order))
+	ret.MetricData = append(ret.MetricData, in.MetricData...)
+	for _, g := range order {
+		ret.MetricData = append(ret.MetricData, g.merge()...)
+	}
+	return &ret
+}
+
+// rollupDatum returns a copy of d with only the dimensions in keep.  It returns nil if d is missing one of the
+// dimensions, or already has exactly those dimensions, since then there is nothing to roll up.
+func rollupDatum(d *cloudwatch.MetricDatum, keep []string) *cloudwatch.MetricDatum {
+	if d == nil {
+		return nil
+	}
+	dims := make([]*cloudwatch.Dimension, 0, len(keep))
+	for _, name := range keep {
+		var found *cloudwatch.Dimension
+		for _, existing := range d.Dimensions {
+			if existing != nil && aws.StringValue(existing.Name) == name {
+				found = existing
+				break
+			}
+		}
+		if found == nil {
+			return nil
+		}
+		dims = append(dims, found)
+	}
+	if len(dims) == len(d.Dimensions) {
+		return nil
+	}
+	ret := *d
+	ret.Dimensions = dims
+	return &ret
+}
+
+// rollupKey identifies the rolled up datum that can be merged together
+func rollupKey(d *cloudwatch.MetricDatum) string {
+	parts := []string{
+		metricIdentity(d),
+		aws.StringValue(d.Unit),
+		strconv.FormatInt(aws.Int64Value(d.StorageResolution), 10),
+	}
+	if d.Timestamp != nil {
+		parts = append(parts, strconv.FormatInt(d.Timestamp.UnixNano(), 10))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// rollupGroup is every rolled up datum that shares a rollupKey
+type rollupGroup struct {
+	datum []*cloudwatch.MetricDatum
+}
+
+// merge combines the group into as few datum as it can.  Datum made only of Value or Values are merged into a Values
+// array, which keeps percentiles intact.  Datum made of Value or StatisticValues, with at least one StatisticValues,
+// are merged into a StatisticSet.  Anything else is left unmerged.
+func (g *rollupGroup) merge() []*cloudwatch.MetricDatum {
+	if len(g.datum) == 1 {
+		return g.datum
+	}
+	hasValues := false
+	hasStatistics := false
+	for _, d := range g.datum {
+		if d.Value == nil && d.StatisticValues == nil && len(d.Values) == 0 {
+			// Invalid datum are sent as is and left to CloudWatch to reject
+			return g.datum
+		}
+		if len(d.Values) != 0 {
+			hasValues = true
+		}
+		if d.StatisticValues != nil {
+			hasStatistics = true
+		}
+	}
+	switch {
+	case hasValues && hasStatistics:
+		return g.datum
+	case hasStatistics:
+		return []*cloudwatch.MetricDatum{g.mergeStatistics()}
+	default:
+		return []*cloudwatch.MetricDatum{g.mergeValues()}
+	}
+}
+
+// mergeValues merges datum that have only Value or Values into one datum with Values and Counts
+func (g *rollupGroup) mergeValues() *cloudwatch.MetricDatum {
+	counts := make(map[float64]float64)
+	order := make([]float64, 0, len(g.datum))
+	add := func(v float64, c float64) {
+		if _, exists := counts[v]; !exists {
+			order = append(order, v)
+		}
+		counts[v] += c
+	}
+	for _, d := range g.datum {
+		if d.Value != nil {
+			add(*d.Value, 1)
+		}
+		for i, v := range d.Values {
+			c := float64(1)
+			if i < len(d.Counts) && d.Counts[i] != nil {
+				c = *d.Counts[i]
+			}
+			add(aws.Float64Value(v), c)
+		}
+	}
+	ret := *g.datum[0]
+	ret.Value = nil
+	ret.Values = make([]*float64, 0, len(order))
+	ret.Counts = make([]*float64, 0, len(order))
+	for _, v := range order {
+		ret.Values = append(ret.Values, aws.Float64(v))
+		ret.Counts = append(ret.Counts, aws.Float64(counts[v]))
+	}
+	return &ret
+}
+
+// mergeStatistics merges datum that have only Value or StatisticValues into one datum with a StatisticSet
+func (g *rollupGroup) mergeStatistics() *cloudwatch.MetricDatum {
+	stats := cloudwatch.StatisticSet{
+		SampleCount: aws.Float64(0),
+		Sum:         aws.Float64(0),
+		Minimum:     aws.Float64(math.Inf(1)),
+		Maximum:     aws.Float64(math.Inf(-1)),
+	}
+	add := func(s *cloudwatch.StatisticSet) {
+		stats.SampleCount = aws.Float64(*stats.SampleCount + aws.Float64Value(s.SampleCount))
+		stats.Sum = aws.Float64(*stats.Sum + aws.Float64Value(s.Sum))
+		if s.Minimum != nil {
+			stats.Minimum = aws.Float64(math.Min(*stats.Minimum, *s.Minimum))
+		}
+		if s.Maximum != nil {
+			stats.Maximum = aws.Float64(math.Max(*stats.Maximum, *s.Maximum))
+		}
+	}
+	for _, d := range g.datum {
+		if d.Value != nil {
+			add(&cloudwatch.StatisticSet{SampleCount: aws.Float64(1), Sum: d.Value, Minimum: d.Value, Maximum: d.Value})
+		}
+		if d.StatisticValues != nil {
+			add(d.StatisticValues)
+		}
+	}
+	ret := *g.datum[0]
+	ret.Value = nil
+	ret.StatisticValues = &stats
+	return &ret
+}
@@ -0,0 +1,98 @@
+package cwpagedmetricput
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollupStage(t *testing.T) {
+	stage := &rollupStage{rollups: []Rollup{{Dimensions: []string{"Service"}}, {}}}
+	in := &cloudwatch.PutMetricDataInput{
+		Namespace: aws.String("ns"),
+		MetricData: []*cloudwatch.MetricDatum{
+			{MetricName: aws.String("m"), Value: aws.Float64(1), Dimensions: []*cloudwatch.Dimension{dim("Service", "a"), dim("Host", "h1")}},
+			{MetricName: aws.String("m"), Value: aws.Float64(3), Dimensions: []*cloudwatch.Dimension{dim("Service", "a"), dim("Host", "h2")}},
+			{MetricName: aws.String("m"), Value: aws.Float64(3), Dimensions: []*cloudwatch.Dimension{dim("Service", "b"), dim("Host", "h3")}},
+		},
+	}
+	out := stage.Process(in)
+	require.Len(t, in.MetricData, 3)
+	// The originals, then Service=a, Service=b, and no dimensions
+	require.Len(t, out.MetricData, 6)
+	require.Equal(t, in.MetricData, out.MetricData[0:3])
+
+	serviceA := out.MetricData[3]
+	require.Equal(t, map[string]string{"Service": "a"}, dimMap(serviceA.Dimensions))
+	require.Nil(t, serviceA.Value)
+	require.Equal(t, []*float64{aws.Float64(1), aws.Float64(3)}, serviceA.Values)
+	require.Equal(t, []*float64{aws.Float64(1), aws.Float64(1)}, serviceA.Counts)
+
+	serviceB := out.MetricData[4]
+	require.Equal(t, map[string]string{"Service": "b"}, dimMap(serviceB.Dimensions))
+	require.Equal(t, 3.0, *serviceB.Value)
+
+	none := out.MetricData[5]
+	require.Empty(t, none.Dimensions)
+	require.Equal(t, []*float64{aws.Float64(1), aws.Float64(3)}, none.Values)
+	require.Equal(t, []*float64{aws.Float64(1), aws.Float64(2)}, none.Counts)
+
+	// The caller's dimensions are untouched
+	require.Len(t, in.MetricData[0].Dimensions, 2)
+}
+
+func TestRollupGroup_merge(t *testing.T) {
+	t.Run("statistics", func(t *testing.T) {
+		g := rollupGroup{datum: []*cloudwatch.MetricDatum{
+			{Value: aws.Float64(5)},
+			{StatisticValues: &cloudwatch.StatisticSet{SampleCount: aws.Float64(2), Sum: aws.Float64(3), Minimum: aws.Float64(1), Maximum: aws.Float64(2)}},
+		}}
+		out := g.merge()
+		require.Len(t, out, 1)
+		require.Nil(t, out[0].Value)
+		require.Equal(t, cloudwatch.StatisticSet{
+			SampleCount: aws.Float64(3), Sum: aws.Float64(8), Minimum: aws.Float64(1), Maximum: aws.Float64(5),
+		}, *out[0].StatisticValues)
+	})
+	t.Run("mixed", func(t *testing.T) {
+		g := rollupGroup{datum: []*cloudwatch.MetricDatum{
+			{Values: []*float64{aws.Float64(5)}},
+			{StatisticValues: &cloudwatch.StatisticSet{SampleCount: aws.Float64(2), Sum: aws.Float64(3), Minimum: aws.Float64(1), Maximum: aws.Float64(2)}},
+		}}
+		require.Len(t, g.merge(), 2)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		g := rollupGroup{datum: []*cloudwatch.MetricDatum{{}, {Value: aws.Float64(1)}}}
+		require.Len(t, g.merge(), 2)
+	})
+}
+
+func Test_rollupDatum(t *testing.T) {
+	d := &cloudwatch.MetricDatum{Dimensions: []*cloudwatch.Dimension{dim("Service", "a")}}
+	require.Nil(t, rollupDatum(d, []string{"Host"}))
+	require.Nil(t, rollupDatum(d, []string{"Service"}))
+	require.Empty(t, rollupDatum(d, nil).Dimensions)
+	require.Nil(t, rollupDatum(nil, nil))
+}
+
+func TestPager_Rollups(t *testing.T) {
+	client := &memoryCloudWatchClient{}
+	p := Pager{
+		Client: client,
+		Config: Config{
+			DefaultDimensions: []*cloudwatch.Dimension{dim("Service", "svc")},
+			Rollups:           []Rollup{{}},
+		},
+	}
+	_, err := p.PutMetricData(&cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String("ns"),
+		MetricData: []*cloudwatch.MetricDatum{{MetricName: aws.String("m"), Value: aws.Float64(1)}},
+	})
+	require.NoError(t, err)
+	require.Len(t, client.in, 1)
+	require.Len(t, client.in[0].MetricData, 2)
+	require.Len(t, client.in[0].MetricData[0].Dimensions, 1)
+	require.Empty(t, client.in[0].MetricData[1].Dimensions)
+}
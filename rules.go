@@ -0,0 +1,256 @@
+package cwpagedmetricput
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// Rules is a declarative description of pipeline stages, intended to be loaded from a config file so filters and
+// renames can change without a code change.  ParseRules loads JSON, and the rulesyaml module loads the same document
+// from YAML.
+type Rules struct {
+	// Allow, if not empty, keeps only datum that match at least one rule
+	Allow []MatchRule `json:"allow,omitempty" yaml:"allow,omitempty"`
+	// Deny drops datum that match any rule.  It runs after Allow.
+	Deny []MatchRule `json:"deny,omitempty" yaml:"deny,omitempty"`
+	// RenameNamespaces rewrites the namespace of the request.  The first matching rule wins.
+	RenameNamespaces []RenameRule `json:"rename_namespaces,omitempty" yaml:"rename_namespaces,omitempty"`
+	// RenameMetrics rewrites metric names.  The first matching rule wins.
+	RenameMetrics []RenameRule `json:"rename_metrics,omitempty" yaml:"rename_metrics,omitempty"`
+	// RenameDimensions rewrites dimension names.  The first matching rule wins.
+	RenameDimensions []RenameRule `json:"rename_dimensions,omitempty" yaml:"rename_dimensions,omitempty"`
+}
+
+// MatchRule matches a datum by metric name and dimensions.  Patterns are globs, where `*` matches any run of
+// characters and `?` matches a single character, unless Regex is set.
+type MatchRule struct {
+	// MetricName is a pattern matched against the whole metric name.  Empty matches every name.
+	MetricName string `json:"metric_name,omitempty" yaml:"metric_name,omitempty"`
+	// Dimensions maps a dimension name to a pattern for its value.  Every listed dimension must exist and match.
+	Dimensions map[string]string `json:"dimensions,omitempty" yaml:"dimensions,omitempty"`
+	// Regex treats patterns as regular expressions instead of globs
+	Regex bool `json:"regex,omitempty" yaml:"regex,omitempty"`
+}
+
+// RenameRule replaces a name matching From with To.  When Regex is set, To may reference capture groups of From
+// with $1 style syntax.
+type RenameRule struct {
+	From  string `json:"from" yaml:"from"`
+	To    string `json:"to" yaml:"to"`
+	Regex bool   `json:"regex,omitempty" yaml:"regex,omitempty"`
+}
+
+// ParseRules decodes a JSON Rules document
+func ParseRules(r io.Reader) (*Rules, error) {
+	var ret Rules
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&ret); err != nil {
+		return nil, fmt.Errorf("unable to decode rules: %v", err)
+	}
+	return &ret, nil
+}
+
+// Stages compiles the rules into pipeline stages, suitable for Config.Stages
+func (r *Rules) Stages() ([]Stage, error) {
+	ret := make([]Stage, 0, 5)
+	if len(r.RenameNamespaces) != 0 {
+		renamer, err := compileRenames(r.RenameNamespaces)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, NamespaceMapper(renamer.rename))
+	}
+	if len(r.Allow) != 0 {
+		allow, err := compileMatchRules(r.Allow)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, Filter(allow.matches))
+	}
+	if len(r.Deny) != 0 {
+		deny, err := compileMatchRules(r.Deny)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, Filter(func(datum *cloudwatch.MetricDatum) bool {
+			return !deny.matches(datum)
+		}))
+	}
+	if len(r.RenameMetrics) != 0 {
+		renamer, err := compileRenames(r.RenameMetrics)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, Mapper(renamer.renameMetric))
+	}
+	if len(r.RenameDimensions) != 0 {
+		renamer, err := compileRenames(r.RenameDimensions)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, Mapper(renamer.renameDimensions))
+	}
+	return ret, nil
+}
+
+// compilePattern turns a glob or regex pattern into a regexp that must match the entire string
+func compilePattern(pattern string, isRegex bool) (*regexp.Regexp, error) {
+	if !isRegex {
+		pattern = regexp.QuoteMeta(pattern)
+		pattern = strings.Replace(pattern, `\*`, ".*", -1)
+		pattern = strings.Replace(pattern, `\?`, ".", -1)
+	}
+	ret, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	return ret, nil
+}
+
+// compiledMatchRule is a MatchRule with its patterns compiled
+type compiledMatchRule struct {
+	metricName *regexp.Regexp
+	dimensions map[string]*regexp.Regexp
+}
+
+func (m *compiledMatchRule) matches(datum *cloudwatch.MetricDatum) bool {
+	if m.metricName != nil && !m.metricName.MatchString(aws.StringValue(datum.MetricName)) {
+		return false
+	}
+	for name, value := range m.dimensions {
+		found := false
+		for _, d := range datum.Dimensions {
+			if d != nil && aws.StringValue(d.Name) == name {
+				found = value.MatchString(aws.StringValue(d.Value))
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// compiledMatchRules matches a datum if any rule matches
+type compiledMatchRules []compiledMatchRule
+
+func (m compiledMatchRules) matches(datum *cloudwatch.MetricDatum) bool {
+	if datum == nil {
+		return false
+	}
+	for i := range m {
+		if m[i].matches(datum) {
+			return true
+		}
+	}
+	return false
+}
+
+func compileMatchRules(rules []MatchRule) (compiledMatchRules, error) {
+	ret := make(compiledMatchRules, 0, len(rules))
+	for _, r := range rules {
+		var c compiledMatchRule
+		var err error
+		if r.MetricName != "" {
+			if c.metricName, err = compilePattern(r.MetricName, r.Regex); err != nil {
+				return nil, err
+			}
+		}
+		c.dimensions = make(map[string]*regexp.Regexp, len(r.Dimensions))
+		for name, value := range r.Dimensions {
+			if c.dimensions[name], err = compilePattern(value, r.Regex); err != nil {
+				return nil, err
+			}
+		}
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
+
+// compiledRename is a RenameRule with its pattern compiled
+type compiledRename struct {
+	from *regexp.Regexp
+	to   string
+}
+
+// compiledRenames applies the first matching rename
+type compiledRenames []compiledRename
+
+func compileRenames(rules []RenameRule) (compiledRenames, error) {
+	ret := make(compiledRenames, 0, len(rules))
+	for _, r := range rules {
+		from, err := compilePattern(r.From, r.Regex)
+		if err != nil {
+			return nil, err
+		}
+		to := r.To
+		if !r.Regex {
+			// Globs have no capture groups, so make sure a stray $ is taken literally
+			to = strings.Replace(to, "$", "$$", -1)
+		}
+		ret = append(ret, compiledRename{from: from, to: to})
+	}
+	return ret, nil
+}
+
+func (c compiledRenames) rename(name string) string {
+	for _, r := range c {
+		if r.from.MatchString(name) {
+			return r.from.ReplaceAllString(name, r.to)
+		}
+	}
+	return name
+}
+
+// renameMetric returns a copy of datum with its metric name renamed
+func (c compiledRenames) renameMetric(datum *cloudwatch.MetricDatum) []*cloudwatch.MetricDatum {
+	if datum == nil || datum.MetricName == nil {
+		return []*cloudwatch.MetricDatum{datum}
+	}
+	newName := c.rename(*datum.MetricName)
+	if newName == *datum.MetricName {
+		return []*cloudwatch.MetricDatum{datum}
+	}
+	ret := *datum
+	ret.MetricName = &newName
+	return []*cloudwatch.MetricDatum{&ret}
+}
+
+// renameDimensions returns a copy of datum with its dimension names renamed
+func (c compiledRenames) renameDimensions(datum *cloudwatch.MetricDatum) []*cloudwatch.MetricDatum {
+	if datum == nil || len(datum.Dimensions) == 0 {
+		return []*cloudwatch.MetricDatum{datum}
+	}
+	var dims []*cloudwatch.Dimension
+	for i, d := range datum.Dimensions {
+		if d == nil || d.Name == nil {
+			continue
+		}
+		newName := c.rename(*d.Name)
+		if newName == *d.Name {
+			continue
+		}
+		if dims == nil {
+			dims = make([]*cloudwatch.Dimension, len(datum.Dimensions))
+			copy(dims, datum.Dimensions)
+		}
+		dims[i] = &cloudwatch.Dimension{
+			Name:  &newName,
+			Value: d.Value,
+		}
+	}
+	if dims == nil {
+		return []*cloudwatch.MetricDatum{datum}
+	}
+	ret := *datum
+	ret.Dimensions = dims
+	return []*cloudwatch.MetricDatum{&ret}
+}
@@ -0,0 +1,86 @@
+package cwpagedmetricput
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+const testRulesDoc = `{
+	"allow": [{"metric_name": "api.*"}, {"dimensions": {"Service": "web"}}],
+	"deny": [{"metric_name": "api\\.debug\\..+", "regex": true}],
+	"rename_namespaces": [{"from": "old/*", "to": "new"}],
+	"rename_metrics": [{"from": "api\\.(.+)", "to": "API.$1", "regex": true}],
+	"rename_dimensions": [{"from": "host", "to": "Host"}]
+}`
+
+func runStages(stages []Stage, in *cloudwatch.PutMetricDataInput) *cloudwatch.PutMetricDataInput {
+	for _, s := range stages {
+		in = s.Process(in)
+	}
+	return in
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(testRulesDoc))
+	require.NoError(t, err)
+	stages, err := rules.Stages()
+	require.NoError(t, err)
+	require.Len(t, stages, 5)
+
+	hostDim := &cloudwatch.Dimension{Name: aws.String("host"), Value: aws.String("h1")}
+	in := &cloudwatch.PutMetricDataInput{
+		Namespace: aws.String("old/service"),
+		MetricData: []*cloudwatch.MetricDatum{
+			{MetricName: aws.String("api.latency"), Dimensions: []*cloudwatch.Dimension{hostDim}},
+			{MetricName: aws.String("api.debug.latency")},
+			{MetricName: aws.String("db.latency")},
+			{MetricName: aws.String("db.errors"), Dimensions: []*cloudwatch.Dimension{{Name: aws.String("Service"), Value: aws.String("web")}}},
+		},
+	}
+	out := runStages(stages, in)
+	require.Equal(t, "new", *out.Namespace)
+	require.Len(t, out.MetricData, 2)
+	require.Equal(t, "API.latency", *out.MetricData[0].MetricName)
+	require.Equal(t, "Host", *out.MetricData[0].Dimensions[0].Name)
+	require.Equal(t, "db.errors", *out.MetricData[1].MetricName)
+
+	// The caller's input is untouched
+	require.Equal(t, "old/service", *in.Namespace)
+	require.Equal(t, "api.latency", *in.MetricData[0].MetricName)
+	require.Equal(t, "host", *hostDim.Name)
+}
+
+func TestParseRules_errors(t *testing.T) {
+	_, err := ParseRules(strings.NewReader(`{"unknown": true}`))
+	require.Error(t, err)
+	rules, err := ParseRules(strings.NewReader(`{"deny": [{"metric_name": "(", "regex": true}]}`))
+	require.NoError(t, err)
+	_, err = rules.Stages()
+	require.Error(t, err)
+}
+
+func Test_compilePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		isRegex bool
+		in      string
+		want    bool
+	}{
+		{pattern: "a*", in: "abc", want: true},
+		{pattern: "a*", in: "bac", want: false},
+		{pattern: "AWS/*", in: "AWS/EC2", want: true},
+		{pattern: "a?c", in: "abc", want: true},
+		{pattern: "a.c", in: "abc", want: false},
+		{pattern: "a.c", isRegex: true, in: "abc", want: true},
+		{pattern: "b", isRegex: true, in: "abc", want: false},
+	}
+	for _, tt := range tests {
+		r, err := compilePattern(tt.pattern, tt.isRegex)
+		require.NoError(t, err)
+		require.Equal(t, tt.want, r.MatchString(tt.in), "%s ~ %s", tt.pattern, tt.in)
+	}
+}
@@ -0,0 +1,20 @@
+module github.com/cep21/cwpagedmetricput/rulesyaml
+
+go 1.24
+
+require (
+	github.com/aws/aws-sdk-go v1.21.6
+	github.com/cep21/cwpagedmetricput v0.0.0-20261018185844-30af357efb5b
+	github.com/stretchr/testify v1.3.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/jmespath/go-jmespath v0.0.0-20180206201540-c2b33e8439af // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+)
+
+// The root module is developed in the same repository.  Releases require a root version that is already published,
+// so tag or push the root module before bumping this requirement.
+replace github.com/cep21/cwpagedmetricput => ../
@@ -0,0 +1,25 @@
+/*
+Package rulesyaml loads cwpagedmetricput.Rules from YAML.  It is a separate module so the core package does not
+depend on a YAML library.
+*/
+package rulesyaml
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/cep21/cwpagedmetricput"
+	"gopkg.in/yaml.v3"
+)
+
+// ParseRules decodes a YAML Rules document.  It uses the same keys as cwpagedmetricput.ParseRules, and unknown keys are
+// an error.
+func ParseRules(r io.Reader) (*cwpagedmetricput.Rules, error) {
+	var ret cwpagedmetricput.Rules
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&ret); err != nil {
+		return nil, fmt.Errorf("unable to decode rules: %v", err)
+	}
+	return &ret, nil
+}
@@ -0,0 +1,57 @@
+package rulesyaml
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/cep21/cwpagedmetricput"
+	"github.com/stretchr/testify/require"
+)
+
+const testRulesDoc = `
+allow:
+  - metric_name: api.*
+deny:
+  - metric_name: 'api\.debug\..+'
+    regex: true
+rename_metrics:
+  - from: 'api\.(.+)'
+    to: API.$1
+    regex: true
+rename_dimensions:
+  - from: host
+    to: Host
+`
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(testRulesDoc))
+	require.NoError(t, err)
+	require.Equal(t, []cwpagedmetricput.MatchRule{{MetricName: "api.*"}}, rules.Allow)
+	stages, err := rules.Stages()
+	require.NoError(t, err)
+	require.Len(t, stages, 4)
+
+	in := &cloudwatch.PutMetricDataInput{
+		Namespace: aws.String("ns"),
+		MetricData: []*cloudwatch.MetricDatum{
+			{MetricName: aws.String("api.latency"), Dimensions: []*cloudwatch.Dimension{{Name: aws.String("host"), Value: aws.String("h1")}}},
+			{MetricName: aws.String("api.debug.latency")},
+			{MetricName: aws.String("db.latency")},
+		},
+	}
+	for _, s := range stages {
+		in = s.Process(in)
+	}
+	require.Len(t, in.MetricData, 1)
+	require.Equal(t, "API.latency", *in.MetricData[0].MetricName)
+	require.Equal(t, "Host", *in.MetricData[0].Dimensions[0].Name)
+}
+
+func TestParseRules_errors(t *testing.T) {
+	for _, doc := range []string{"", "allow: {", "unknown: true", "allow:\n  - metric: a"} {
+		_, err := ParseRules(strings.NewReader(doc))
+		require.Error(t, err, doc)
+	}
+}
@@ -0,0 +1,200 @@
+package sdkv2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	v1aws "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	v1cloudwatch "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/cep21/cwpagedmetricput"
+)
+
+// v1Client is the cwpagedmetricput.CloudWatchClient the core Pager sends through.  The v1 request.Options the core
+// Pager adds, like body encoding and size reporting, run against a v1 request.Request that mirrors the v2 request.
+type v1Client struct {
+	client Client
+}
+
+var _ cwpagedmetricput.CloudWatchClient = &v1Client{}
+
+// PutMetricDataWithContext sends in with the v2 Client
+func (c *v1Client) PutMetricDataWithContext(ctx v1aws.Context, in *v1cloudwatch.PutMetricDataInput, opts ...request.Option) (*v1cloudwatch.PutMetricDataOutput, error) {
+	httpReq, err := http.NewRequest(http.MethodPost, "/", nil)
+	if err != nil {
+		return nil, err
+	}
+	r := &request.Request{
+		HTTPRequest: httpReq,
+	}
+	r.ApplyOptions(opts...)
+	optFns, _ := ctx.Value(optFnsKey{}).([]func(*cloudwatch.Options))
+	optFns = append(optFns[:len(optFns):len(optFns)], func(o *cloudwatch.Options) {
+		// The v1 options do the encoding, with a size limit the built in compression does not have
+		o.DisableRequestCompression = true
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			if err := stack.Serialize.Add(&v1BuildMiddleware{r: r}, middleware.After); err != nil {
+				return err
+			}
+			return stack.Finalize.Add(&attemptCountMiddleware{r: r}, middleware.After)
+		})
+	})
+	out, err := c.client.PutMetricData(ctx, toV2Input(in), optFns...)
+	if out != nil {
+		r.RequestID, _ = awsmiddleware.GetRequestIDMetadata(out.ResultMetadata)
+	}
+	err = toV1Error(err)
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		r.RequestID = reqErr.RequestID()
+	}
+	r.Error = err
+	r.Handlers.Complete.Run(r)
+	if err != nil {
+		return nil, err
+	}
+	return &v1cloudwatch.PutMetricDataOutput{}, nil
+}
+
+// v1BuildMiddleware runs the Build handlers of a v1 request.Request on the serialized body of a v2 request, then sends
+// the body and Content-Encoding they produce
+type v1BuildMiddleware struct {
+	r *request.Request
+}
+
+func (m *v1BuildMiddleware) ID() string {
+	return "cwpagedmetricput.v1Build"
+}
+
+func (m *v1BuildMiddleware) HandleSerialize(ctx context.Context, in middleware.SerializeInput, next middleware.SerializeHandler) (middleware.SerializeOutput, middleware.Metadata, error) {
+	req, ok := in.Request.(*smithyhttp.Request)
+	if !ok {
+		return middleware.SerializeOutput{}, middleware.Metadata{}, fmt.Errorf("unknown request type %T", in.Request)
+	}
+	var body []byte
+	if stream := req.GetStream(); stream != nil {
+		var err error
+		if body, err = io.ReadAll(stream); err != nil {
+			return middleware.SerializeOutput{}, middleware.Metadata{}, err
+		}
+	}
+	m.r.SetReaderBody(bytes.NewReader(body))
+	m.r.Handlers.Build.Run(m.r)
+	if m.r.Error != nil {
+		return middleware.SerializeOutput{}, middleware.Metadata{}, m.r.Error
+	}
+	encoded, err := io.ReadAll(m.r.GetBody())
+	if err != nil {
+		return middleware.SerializeOutput{}, middleware.Metadata{}, err
+	}
+	if contentEncoding := m.r.HTTPRequest.Header.Get("Content-Encoding"); contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+	newReq, err := req.SetStream(bytes.NewReader(encoded))
+	if err != nil {
+		return middleware.SerializeOutput{}, middleware.Metadata{}, err
+	}
+	in.Request = newReq
+	return next.HandleSerialize(ctx, in)
+}
+
+// attemptCountMiddleware runs once per attempt, so the v1 request.Request can report retries
+type attemptCountMiddleware struct {
+	r        *request.Request
+	attempts int
+}
+
+func (m *attemptCountMiddleware) ID() string {
+	return "cwpagedmetricput.attemptCount"
+}
+
+func (m *attemptCountMiddleware) HandleFinalize(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+	m.attempts++
+	m.r.RetryCount = m.attempts - 1
+	return next.HandleFinalize(ctx, in)
+}
+
+// sizeError matches the errors cwpagedmetricput.IsRequestSizeError is true for
+type sizeError interface {
+	RequestSizeError()
+	error
+}
+
+// toV1Error unwraps err into what the core Pager expects: size errors as themselves, and API errors as an
+// awserr.RequestFailure so they can be logged and checked for rejected compression the same way as v1 errors
+func toV1Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	var sizeErr sizeError
+	if errors.As(err, &sizeErr) {
+		return sizeErr
+	}
+	var respErr *awshttp.ResponseError
+	if !errors.As(err, &respErr) {
+		return err
+	}
+	ret := &requestFailure{
+		err:        err,
+		code:       "Unknown",
+		message:    err.Error(),
+		statusCode: respErr.HTTPStatusCode(),
+		requestID:  respErr.ServiceRequestID(),
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		ret.code = apiErr.ErrorCode()
+		ret.message = apiErr.ErrorMessage()
+	}
+	return ret
+}
+
+// requestFailure is an awserr.RequestFailure for a v2 error.  It unwraps to the v2 error, so callers can still use
+// errors.As with v2 error types.
+type requestFailure struct {
+	err        error
+	code       string
+	message    string
+	statusCode int
+	requestID  string
+}
+
+var _ awserr.RequestFailure = &requestFailure{}
+
+func (e *requestFailure) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestFailure) Unwrap() error {
+	return e.err
+}
+
+func (e *requestFailure) Code() string {
+	return e.code
+}
+
+func (e *requestFailure) Message() string {
+	return e.message
+}
+
+func (e *requestFailure) OrigErr() error {
+	return e.err
+}
+
+func (e *requestFailure) StatusCode() int {
+	return e.statusCode
+}
+
+func (e *requestFailure) RequestID() string {
+	return e.requestID
+}
@@ -0,0 +1,130 @@
+package sdkv2
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	v1cloudwatch "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// toV1Input converts a v2 input to the v1 input the core Pager pages.  EntityMetricData is not converted.
+func toV1Input(in *cloudwatch.PutMetricDataInput) *v1cloudwatch.PutMetricDataInput {
+	ret := &v1cloudwatch.PutMetricDataInput{
+		Namespace:  in.Namespace,
+		MetricData: make([]*v1cloudwatch.MetricDatum, 0, len(in.MetricData)),
+	}
+	for i := range in.MetricData {
+		ret.MetricData = append(ret.MetricData, toV1Datum(&in.MetricData[i]))
+	}
+	return ret
+}
+
+func toV1Datum(d *types.MetricDatum) *v1cloudwatch.MetricDatum {
+	ret := &v1cloudwatch.MetricDatum{
+		MetricName: d.MetricName,
+		Timestamp:  d.Timestamp,
+		Value:      d.Value,
+		Values:     toV1Floats(d.Values),
+		Counts:     toV1Floats(d.Counts),
+	}
+	if d.Unit != "" {
+		unit := string(d.Unit)
+		ret.Unit = &unit
+	}
+	if d.StorageResolution != nil {
+		resolution := int64(*d.StorageResolution)
+		ret.StorageResolution = &resolution
+	}
+	if d.StatisticValues != nil {
+		ret.StatisticValues = &v1cloudwatch.StatisticSet{
+			Maximum:     d.StatisticValues.Maximum,
+			Minimum:     d.StatisticValues.Minimum,
+			SampleCount: d.StatisticValues.SampleCount,
+			Sum:         d.StatisticValues.Sum,
+		}
+	}
+	if d.Dimensions != nil {
+		ret.Dimensions = make([]*v1cloudwatch.Dimension, 0, len(d.Dimensions))
+		for _, dim := range d.Dimensions {
+			ret.Dimensions = append(ret.Dimensions, &v1cloudwatch.Dimension{
+				Name:  dim.Name,
+				Value: dim.Value,
+			})
+		}
+	}
+	return ret
+}
+
+func toV1Floats(in []float64) []*float64 {
+	if in == nil {
+		return nil
+	}
+	ret := make([]*float64, len(in))
+	for i := range in {
+		ret[i] = &in[i]
+	}
+	return ret
+}
+
+// toV2Input converts a v1 input from the core Pager to the v2 input Client sends
+func toV2Input(in *v1cloudwatch.PutMetricDataInput) *cloudwatch.PutMetricDataInput {
+	ret := &cloudwatch.PutMetricDataInput{
+		Namespace:  in.Namespace,
+		MetricData: make([]types.MetricDatum, 0, len(in.MetricData)),
+	}
+	for _, d := range in.MetricData {
+		if d != nil {
+			ret.MetricData = append(ret.MetricData, toV2Datum(d))
+		}
+	}
+	return ret
+}
+
+func toV2Datum(d *v1cloudwatch.MetricDatum) types.MetricDatum {
+	ret := types.MetricDatum{
+		MetricName: d.MetricName,
+		Timestamp:  d.Timestamp,
+		Value:      d.Value,
+		Values:     toV2Floats(d.Values),
+		Counts:     toV2Floats(d.Counts),
+	}
+	if d.Unit != nil {
+		ret.Unit = types.StandardUnit(*d.Unit)
+	}
+	if d.StorageResolution != nil {
+		resolution := int32(*d.StorageResolution)
+		ret.StorageResolution = &resolution
+	}
+	if d.StatisticValues != nil {
+		ret.StatisticValues = &types.StatisticSet{
+			Maximum:     d.StatisticValues.Maximum,
+			Minimum:     d.StatisticValues.Minimum,
+			SampleCount: d.StatisticValues.SampleCount,
+			Sum:         d.StatisticValues.Sum,
+		}
+	}
+	if d.Dimensions != nil {
+		ret.Dimensions = make([]types.Dimension, 0, len(d.Dimensions))
+		for _, dim := range d.Dimensions {
+			if dim != nil {
+				ret.Dimensions = append(ret.Dimensions, types.Dimension{
+					Name:  dim.Name,
+					Value: dim.Value,
+				})
+			}
+		}
+	}
+	return ret
+}
+
+func toV2Floats(in []*float64) []float64 {
+	if in == nil {
+		return nil
+	}
+	ret := make([]float64, len(in))
+	for i, f := range in {
+		if f != nil {
+			ret[i] = *f
+		}
+	}
+	return ret
+}
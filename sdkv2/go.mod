@@ -0,0 +1,24 @@
+module github.com/cep21/cwpagedmetricput/sdkv2
+
+go 1.24
+
+require (
+	github.com/aws/aws-sdk-go v1.21.6
+	github.com/aws/aws-sdk-go-v2 v1.41.9
+	github.com/aws/aws-sdk-go-v2/service/cloudwatch v1.57.2
+	github.com/aws/smithy-go v1.26.0
+	github.com/cep21/cwpagedmetricput v0.0.0-20261018191559-e4e50fcb1748
+	github.com/stretchr/testify v1.3.0
+)
+
+require (
+	github.com/aws/aws-sdk-go-v2/internal/configsources v1.4.25 // indirect
+	github.com/aws/aws-sdk-go-v2/internal/endpoints/v2 v2.7.25 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/jmespath/go-jmespath v0.0.0-20180206201540-c2b33e8439af // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+)
+
+// The root module is developed in the same repository.  Releases require a root version that is already published,
+// so tag or push the root module before bumping this requirement.
+replace github.com/cep21/cwpagedmetricput => ../
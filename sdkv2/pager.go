@@ -0,0 +1,64 @@
+// Package sdkv2 adapts cwpagedmetricput.Pager to aws-sdk-go-v2.  The v1 Pager does all of the filtering, splitting,
+// bucketing, and bisection, so both SDKs page metrics identically.  Request bodies are encoded by a v2 serialize
+// middleware that runs the same encoding as the v1 Pager.
+package sdkv2
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/cep21/cwpagedmetricput"
+)
+
+// Client is the part of *cloudwatch.Client that Pager uses
+type Client interface {
+	PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error)
+}
+
+var _ Client = &cloudwatch.Client{}
+var _ Client = &Pager{}
+
+// Pager behaves like the v2 *cloudwatch.Client's PutMetricData, but splits and buckets datum the same way
+// cwpagedmetricput.Pager does.  It is as thread safe as Client.  Pager should not be copied after first use.
+type Pager struct {
+	// Client is required and is usually an instance of *cloudwatch.Client
+	Client Client
+	// Config is optional and controls how data is filtered, aggregated, and encoded
+	Config cwpagedmetricput.Config
+
+	once sync.Once
+	core *cwpagedmetricput.Pager
+}
+
+// Core returns the v1 Pager that pages every request.  Use it for Stats, Usage, and Plan.  The v2 SDK serializes
+// requests with a more compact protocol than v1, so sizes from Plan are usually larger than what this
+// Pager sends.
+func (p *Pager) Core() *cwpagedmetricput.Pager {
+	p.once.Do(func() {
+		p.core = &cwpagedmetricput.Pager{
+			Client: &v1Client{client: p.Client},
+			Config: p.Config,
+		}
+	})
+	return p.core
+}
+
+// PutMetricData is a drop in replacement for *cloudwatch.Client.PutMetricData that takes care of splitting datum
+// that are too large.  optFns are passed to every request sent.  Inputs with EntityMetricData are sent to Client
+// unchanged, since entities cannot be split the way plain datum are.
+func (p *Pager) PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error) {
+	if params == nil || len(params.EntityMetricData) != 0 {
+		return p.Client.PutMetricData(ctx, params, optFns...)
+	}
+	if len(optFns) != 0 {
+		ctx = context.WithValue(ctx, optFnsKey{}, optFns)
+	}
+	if _, err := p.Core().PutMetricDataWithContext(ctx, toV1Input(params)); err != nil {
+		return nil, err
+	}
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+// optFnsKey holds the caller's optFns in the context of each PutMetricData call
+type optFnsKey struct{}
@@ -0,0 +1,254 @@
+package sdkv2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	v1cloudwatch "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/encoding/cbor"
+	"github.com/cep21/cwpagedmetricput"
+	"github.com/stretchr/testify/require"
+)
+
+// sentRequest is a request fakeHTTPClient received
+type sentRequest struct {
+	contentEncoding string
+	datumCount      int
+	size            int
+}
+
+// fakeHTTPClient answers PutMetricData requests without a network
+type fakeHTTPClient struct {
+	mu   sync.Mutex
+	sent []sentRequest
+	// respond, if set, returns the status and error type to answer a request with.  An empty error type succeeds.
+	respond func(call int, req sentRequest) (int, string)
+}
+
+func (f *fakeHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	req := sentRequest{
+		contentEncoding: r.Header.Get("Content-Encoding"),
+		size:            len(body),
+	}
+	if req.contentEncoding == "gzip" {
+		gzipR, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if body, err = io.ReadAll(gzipR); err != nil {
+			return nil, err
+		}
+	}
+	decoded, err := cbor.Decode(body)
+	if err != nil {
+		return nil, err
+	}
+	req.datumCount = len(decoded.(cbor.Map)["MetricData"].(cbor.List))
+
+	f.mu.Lock()
+	call := len(f.sent)
+	f.sent = append(f.sent, req)
+	f.mu.Unlock()
+
+	status, errType := http.StatusOK, ""
+	if f.respond != nil {
+		status, errType = f.respond(call, req)
+	}
+	respBody := cbor.Map{}
+	if errType != "" {
+		respBody["__type"] = cbor.String(errType)
+		respBody["message"] = cbor.String("rejected by test")
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header: http.Header{
+			"Smithy-Protocol":  []string{"rpc-v2-cbor"},
+			"X-Amzn-Requestid": []string{fmt.Sprintf("request-%d", call)},
+		},
+		Body:    io.NopCloser(bytes.NewReader(cbor.Encode(respBody))),
+		Request: r,
+	}, nil
+}
+
+func testClient(httpClient *fakeHTTPClient) *cloudwatch.Client {
+	return cloudwatch.New(cloudwatch.Options{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+		HTTPClient:  httpClient,
+		Retryer: retry.NewStandard(func(o *retry.StandardOptions) {
+			o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) {
+				return 0, nil
+			})
+		}),
+	})
+}
+
+func testInput(count int, dimValueSize int) *cloudwatch.PutMetricDataInput {
+	ret := &cloudwatch.PutMetricDataInput{
+		Namespace: aws.String("ns"),
+	}
+	for i := 0; i < count; i++ {
+		d := types.MetricDatum{
+			MetricName: aws.String(fmt.Sprintf("metric%d", i)),
+			Value:      aws.Float64(float64(i)),
+			Unit:       types.StandardUnitCount,
+		}
+		if dimValueSize > 0 {
+			for j := 0; j < 30; j++ {
+				d.Dimensions = append(d.Dimensions, types.Dimension{
+					Name:  aws.String(fmt.Sprintf("dim%d", j)),
+					Value: aws.String(randomString(dimValueSize)),
+				})
+			}
+		}
+		ret.MetricData = append(ret.MetricData, d)
+	}
+	return ret
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('a' + rand.Intn(26))
+	}
+	return string(b)
+}
+
+func totalDatum(sent []sentRequest) int {
+	ret := 0
+	for _, s := range sent {
+		ret += s.datumCount
+	}
+	return ret
+}
+
+func TestPager_PutMetricData(t *testing.T) {
+	t.Run("buckets", func(t *testing.T) {
+		httpClient := &fakeHTTPClient{}
+		p := &Pager{Client: testClient(httpClient)}
+		_, err := p.PutMetricData(context.Background(), testInput(45, 0))
+		require.NoError(t, err)
+		require.Len(t, httpClient.sent, 3)
+		require.Equal(t, 45, totalDatum(httpClient.sent))
+		for _, s := range httpClient.sent {
+			require.Equal(t, "gzip", s.contentEncoding)
+		}
+		stats := p.Core().Stats()
+		require.Equal(t, int64(1), stats.Calls)
+		require.Equal(t, int64(3), stats.Buckets)
+		require.True(t, stats.BytesCompressed > 0)
+		require.True(t, p.Core().Usage().PayloadBytes > 0)
+	})
+	t.Run("bisects", func(t *testing.T) {
+		httpClient := &fakeHTTPClient{}
+		p := &Pager{Client: testClient(httpClient)}
+		_, err := p.PutMetricData(context.Background(), testInput(20, 250))
+		require.NoError(t, err)
+		require.True(t, len(httpClient.sent) > 1)
+		require.Equal(t, 20, totalDatum(httpClient.sent))
+		for _, s := range httpClient.sent {
+			require.True(t, s.size <= cwpagedmetricput.PutMetricDataSizeLimit)
+		}
+		require.True(t, p.Core().Stats().Bisections > 0)
+	})
+	t.Run("uncompressed", func(t *testing.T) {
+		httpClient := &fakeHTTPClient{}
+		p := &Pager{Client: testClient(httpClient), Config: cwpagedmetricput.Config{DisableCompression: true}}
+		_, err := p.PutMetricData(context.Background(), testInput(1, 0))
+		require.NoError(t, err)
+		require.Len(t, httpClient.sent, 1)
+		require.Equal(t, "", httpClient.sent[0].contentEncoding)
+	})
+	t.Run("compression fallback", func(t *testing.T) {
+		httpClient := &fakeHTTPClient{
+			respond: func(_ int, req sentRequest) (int, string) {
+				if req.contentEncoding != "" {
+					return http.StatusUnsupportedMediaType, "UnsupportedMediaType"
+				}
+				return http.StatusOK, ""
+			},
+		}
+		p := &Pager{Client: testClient(httpClient), Config: cwpagedmetricput.Config{CompressionFallback: true}}
+		_, err := p.PutMetricData(context.Background(), testInput(1, 0))
+		require.NoError(t, err)
+		require.True(t, p.Core().CompressionRejected())
+		require.Len(t, httpClient.sent, 2)
+	})
+	t.Run("retries", func(t *testing.T) {
+		httpClient := &fakeHTTPClient{
+			respond: func(call int, _ sentRequest) (int, string) {
+				if call == 0 {
+					return http.StatusInternalServerError, "InternalServiceFault"
+				}
+				return http.StatusOK, ""
+			},
+		}
+		p := &Pager{Client: testClient(httpClient)}
+		_, err := p.PutMetricData(context.Background(), testInput(1, 0))
+		require.NoError(t, err)
+		require.Len(t, httpClient.sent, 2)
+		require.Equal(t, int64(1), p.Core().Stats().Retries)
+	})
+	t.Run("errors", func(t *testing.T) {
+		httpClient := &fakeHTTPClient{
+			respond: func(int, sentRequest) (int, string) {
+				return http.StatusForbidden, "AccessDenied"
+			},
+		}
+		var dropped int
+		p := &Pager{Client: testClient(httpClient), Config: cwpagedmetricput.Config{
+			OnDroppedDatum: func(*v1cloudwatch.MetricDatum) {
+				dropped++
+			},
+		}}
+		_, err := p.PutMetricData(context.Background(), testInput(2, 0))
+		require.Error(t, err)
+		var apiErr smithy.APIError
+		require.True(t, errors.As(err, &apiErr))
+		require.Equal(t, "AccessDenied", apiErr.ErrorCode())
+		require.Equal(t, 2, dropped)
+	})
+	t.Run("optFns", func(t *testing.T) {
+		httpClient := &fakeHTTPClient{}
+		p := &Pager{Client: testClient(&fakeHTTPClient{})}
+		_, err := p.PutMetricData(context.Background(), testInput(1, 0), func(o *cloudwatch.Options) {
+			o.HTTPClient = httpClient
+		})
+		require.NoError(t, err)
+		require.Len(t, httpClient.sent, 1)
+	})
+}
+
+func Test_conversion(t *testing.T) {
+	in := types.MetricDatum{
+		MetricName:        aws.String("m"),
+		Counts:            []float64{1, 2},
+		Dimensions:        []types.Dimension{{Name: aws.String("a"), Value: aws.String("b")}},
+		StatisticValues:   &types.StatisticSet{Maximum: aws.Float64(1), Minimum: aws.Float64(0), SampleCount: aws.Float64(2), Sum: aws.Float64(1)},
+		StorageResolution: aws.Int32(1),
+		Timestamp:         aws.Time(time.Unix(1000, 0)),
+		Unit:              types.StandardUnitSeconds,
+		Value:             aws.Float64(3),
+		Values:            []float64{4, 5},
+	}
+	require.Equal(t, in, toV2Datum(toV1Datum(&in)))
+	require.Equal(t, types.MetricDatum{}, toV2Datum(toV1Datum(&types.MetricDatum{})))
+}
@@ -0,0 +1,195 @@
+package cwpagedmetricput
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// SelfReporter periodically publishes a Pager's own Stats to CloudWatch, through that same Pager, so alarms can fire
+// when the metrics pipeline itself is dropping data.  Each report publishes the change in Stats since the previous
+// report.
+//
+// Self metrics are guarded so they cannot recurse or amplify load: only one report is ever in flight, a failed report
+// is never retried and backs off the following reports, and Config.OnDroppedDatum is not called for self metrics.
+// Self metrics also skip Config.Stages, Rollups, the CardinalityGuard, and the Budget, and are left out of Stats, so
+// they report only on the caller's metrics.  They are still counted in Usage, since CloudWatch bills for them.
+type SelfReporter struct {
+	// Pager is required and is the Pager to report on and publish through
+	Pager *Pager
+	// Namespace is where self metrics are published.  Defaults to DefaultSelfMetricsNamespace.
+	Namespace string
+	// Interval is how often to report.  Defaults to one minute.
+	Interval time.Duration
+	// Dimensions are added to every self metric, usually to identify the process reporting
+	Dimensions []*cloudwatch.Dimension
+	// OnError, if set, is called with the error of each failed report
+	OnError func(err error)
+
+	inFlight int32
+	last     Stats
+}
+
+// DefaultSelfMetricsNamespace is the namespace a SelfReporter publishes to by default
+const DefaultSelfMetricsNamespace = "cwpagedmetricput"
+
+// maxSelfReportBackoff is the most report intervals a SelfReporter skips after repeated failures
+const maxSelfReportBackoff = 16
+
+const defaultSelfReportInterval = time.Minute
+
+// selfMetricsKey marks a context as publishing self metrics
+type selfMetricsKey struct{}
+
+// isSelfMetrics returns true if ctx is publishing a SelfReporter's metrics
+func isSelfMetrics(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	v, _ := ctx.Value(selfMetricsKey{}).(bool)
+	return v
+}
+
+func (s *SelfReporter) interval() time.Duration {
+	if s.Interval <= 0 {
+		return defaultSelfReportInterval
+	}
+	return s.Interval
+}
+
+func (s *SelfReporter) namespace() string {
+	if s.Namespace == "" {
+		return DefaultSelfMetricsNamespace
+	}
+	return s.Namespace
+}
+
+// Run reports every Interval until ctx ends.  It always returns ctx's error.
+func (s *SelfReporter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval())
+	defer ticker.Stop()
+	backoff := 0
+	skip := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if err := s.Report(ctx); err != nil {
+			if s.OnError != nil {
+				s.OnError(err)
+			}
+			// Back off exponentially so a struggling CloudWatch isn't sent more load
+			backoff *= 2
+			if backoff == 0 {
+				backoff = 1
+			}
+			if backoff > maxSelfReportBackoff {
+				backoff = maxSelfReportBackoff
+			}
+			skip = backoff
+			continue
+		}
+		backoff = 0
+	}
+}
+
+// errReportInFlight is returned by Report when another Report has not finished
+var errReportInFlight = errors.New("self metrics report already in flight")
+
+// Report publishes the change in Stats since the previous Report.  The change is consumed even if publishing fails,
+// so failures never grow the size of later reports.
+func (s *SelfReporter) Report(ctx context.Context) error {
+	if s.Pager == nil {
+		return errors.New("SelfReporter requires a Pager")
+	}
+	if !atomic.CompareAndSwapInt32(&s.inFlight, 0, 1) {
+		return errReportInFlight
+	}
+	defer atomic.StoreInt32(&s.inFlight, 0)
+	current := s.Pager.Stats()
+	datum := s.datum(current, s.last, time.Now())
+	s.last = current
+
+	ctx, cancel := context.WithTimeout(context.WithValue(ctx, selfMetricsKey{}, true), s.interval())
+	defer cancel()
+	_, err := s.Pager.PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String(s.namespace()),
+		MetricData: datum,
+	})
+	return err
+}
+
+// datum turns the difference between two Stats into datum
+func (s *SelfReporter) datum(current Stats, last Stats, now time.Time) []*cloudwatch.MetricDatum {
+	counter := func(name string, unit string, value int64, extraDims ...*cloudwatch.Dimension) *cloudwatch.MetricDatum {
+		dims := make([]*cloudwatch.Dimension, 0, len(s.Dimensions)+len(extraDims))
+		dims = append(dims, s.Dimensions...)
+		dims = append(dims, extraDims...)
+		return &cloudwatch.MetricDatum{
+			MetricName: aws.String(name),
+			Dimensions: dims,
+			Timestamp:  &now,
+			Unit:       aws.String(unit),
+			Value:      aws.Float64(float64(value)),
+		}
+	}
+	ret := []*cloudwatch.MetricDatum{
+		counter("Calls", cloudwatch.StandardUnitCount, current.Calls-last.Calls),
+		counter("DatumIn", cloudwatch.StandardUnitCount, current.DatumIn-last.DatumIn),
+		counter("DatumSplit", cloudwatch.StandardUnitCount, current.DatumSplit-last.DatumSplit),
+		counter("Buckets", cloudwatch.StandardUnitCount, current.Buckets-last.Buckets),
+		counter("Bisections", cloudwatch.StandardUnitCount, current.Bisections-last.Bisections),
+		counter("BytesUncompressed", cloudwatch.StandardUnitBytes, current.BytesUncompressed-last.BytesUncompressed),
+		counter("BytesCompressed", cloudwatch.StandardUnitBytes, current.BytesCompressed-last.BytesCompressed),
+		counter("Retries", cloudwatch.StandardUnitCount, current.Retries-last.Retries),
+	}
+	for _, reason := range allDropReasons {
+		ret = append(ret, counter("DroppedDatum", cloudwatch.StandardUnitCount, current.Dropped[reason]-last.Dropped[reason], &cloudwatch.Dimension{
+			Name:  aws.String("Reason"),
+			Value: aws.String(string(reason)),
+		}))
+	}
+	if latency := s.latencyDatum(current.SendLatency, last.SendLatency, now); latency != nil {
+		ret = append(ret, latency)
+	}
+	return ret
+}
+
+// latencyDatum turns the difference between two latency histograms into a datum of Values and Counts, using each
+// bucket's upper bound as its value.  It returns nil if nothing was observed.
+func (s *SelfReporter) latencyDatum(current LatencyHistogram, last LatencyHistogram, now time.Time) *cloudwatch.MetricDatum {
+	ret := &cloudwatch.MetricDatum{
+		MetricName: aws.String("SendLatency"),
+		Dimensions: s.Dimensions,
+		Timestamp:  &now,
+		Unit:       aws.String(cloudwatch.StandardUnitMilliseconds),
+	}
+	for i, count := range current.Counts {
+		if i < len(last.Counts) {
+			count -= last.Counts[i]
+		}
+		if count <= 0 {
+			continue
+		}
+		bound := current.Bounds[len(current.Bounds)-1] * 2
+		if i < len(current.Bounds) {
+			bound = current.Bounds[i]
+		}
+		ret.Values = append(ret.Values, aws.Float64(float64(bound)/float64(time.Millisecond)))
+		ret.Counts = append(ret.Counts, aws.Float64(float64(count)))
+	}
+	if len(ret.Values) == 0 {
+		return nil
+	}
+	return ret
+}
@@ -0,0 +1,122 @@
+package cwpagedmetricput
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+func findDatum(in []*cloudwatch.MetricDatum, name string) *cloudwatch.MetricDatum {
+	for _, d := range in {
+		if *d.MetricName == name {
+			return d
+		}
+	}
+	return nil
+}
+
+func TestSelfReporter_Report(t *testing.T) {
+	client := &memoryCloudWatchClient{}
+	p := &Pager{Client: client}
+	r := SelfReporter{
+		Pager:      p,
+		Dimensions: []*cloudwatch.Dimension{dim("Host", "h1")},
+	}
+	_, err := p.PutMetricData(guardInput(0, 3))
+	require.NoError(t, err)
+
+	require.NoError(t, r.Report(context.Background()))
+	require.Len(t, client.in, 2)
+	self := client.in[1]
+	require.Equal(t, DefaultSelfMetricsNamespace, *self.Namespace)
+	require.Equal(t, 3.0, *findDatum(self.MetricData, "DatumIn").Value)
+	require.Equal(t, 1.0, *findDatum(self.MetricData, "Calls").Value)
+	require.Equal(t, map[string]string{"Host": "h1"}, dimMap(findDatum(self.MetricData, "Calls").Dimensions))
+	require.NotNil(t, findDatum(self.MetricData, "SendLatency"))
+
+	// The second report only has what changed, and the first report is not counted
+	require.NoError(t, r.Report(context.Background()))
+	self = client.in[2]
+	require.Equal(t, 0.0, *findDatum(self.MetricData, "DatumIn").Value)
+	require.Equal(t, int64(1), p.Stats().Calls)
+}
+
+func TestSelfReporter_skipsRules(t *testing.T) {
+	client := &memoryCloudWatchClient{}
+	p := &Pager{
+		Client: client,
+		Config: Config{
+			DefaultDimensions: []*cloudwatch.Dimension{dim("Service", "api")},
+			Stages: []Stage{Filter(func(*cloudwatch.MetricDatum) bool {
+				return false
+			})},
+			CardinalityGuard: &CardinalityGuard{MaxMetrics: 1},
+			Budget: &Budget{
+				MaxRequests: 1,
+				Degrade: []Stage{Filter(func(*cloudwatch.MetricDatum) bool {
+					return false
+				})},
+			},
+		},
+	}
+	r := SelfReporter{Pager: p}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, r.Report(context.Background()))
+	}
+	require.Len(t, client.in, 3)
+	self := client.in[2]
+	require.Equal(t, len(client.in[0].MetricData), len(self.MetricData))
+	require.Equal(t, map[string]string{"Service": "api"}, dimMap(findDatum(self.MetricData, "Calls").Dimensions))
+	require.Equal(t, int64(0), p.Stats().Calls)
+}
+
+func TestSelfReporter_noRecursion(t *testing.T) {
+	client := &memoryCloudWatchClient{errOnCall: 1, err: errors.New("down")}
+	callbacks := 0
+	p := &Pager{
+		Client: client,
+		Config: Config{
+			OnDroppedDatum: func(*cloudwatch.MetricDatum) {
+				callbacks++
+			},
+		},
+	}
+	r := SelfReporter{Pager: p}
+	require.Error(t, r.Report(context.Background()))
+	require.Equal(t, 0, callbacks)
+	require.Equal(t, int64(0), p.Stats().Dropped[DropReasonSendError])
+
+	r.inFlight = 1
+	require.Equal(t, errReportInFlight, r.Report(context.Background()))
+}
+
+func TestSelfReporter_Run(t *testing.T) {
+	client := &memoryCloudWatchClient{}
+	r := SelfReporter{
+		Pager:     &Pager{Client: client},
+		Namespace: "custom",
+		Interval:  time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for {
+			client.mu.Lock()
+			sent := len(client.in)
+			client.mu.Unlock()
+			if sent >= 2 {
+				cancel()
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	require.Equal(t, context.Canceled, r.Run(ctx))
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	require.Equal(t, aws.String("custom"), client.in[0].Namespace)
+}
@@ -0,0 +1,211 @@
+package cwpagedmetricput
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// DropReason is why Pager dropped a datum instead of sending it
+type DropReason string
+
+const (
+	// DropReasonTooLarge is a single datum that is too large to send, even alone
+	DropReasonTooLarge DropReason = "too_large"
+	// DropReasonSendError is a datum in a request that CloudWatch returned an error for
+	DropReasonSendError DropReason = "send_error"
+	// DropReasonFiltered is a datum removed by a Stage
+	DropReasonFiltered DropReason = "filtered"
+	// DropReasonCardinality is a datum removed by the CardinalityGuard
+	DropReasonCardinality DropReason = "cardinality"
+	// DropReasonBudget is a datum removed by a Budget's Degrade stages
+	DropReasonBudget DropReason = "budget"
+)
+
+// allDropReasons lists every DropReason, in the order pagerStats stores them
+var allDropReasons = [...]DropReason{DropReasonTooLarge, DropReasonSendError, DropReasonFiltered, DropReasonCardinality, DropReasonBudget}
+
+// latencyBounds are the upper bounds of each LatencyHistogram bucket, except the last bucket which has no bound
+var latencyBounds = [...]time.Duration{
+	time.Millisecond,
+	time.Millisecond * 5,
+	time.Millisecond * 10,
+	time.Millisecond * 25,
+	time.Millisecond * 50,
+	time.Millisecond * 100,
+	time.Millisecond * 250,
+	time.Millisecond * 500,
+	time.Second,
+	time.Second * 2,
+	time.Second * 5,
+	time.Second * 10,
+}
+
+// Stats is a snapshot of what a Pager has done since it was created.  Every field only ever increases.
+type Stats struct {
+	// Calls is the number of PutMetricData calls made to the Pager
+	Calls int64
+	// DatumIn is the number of datum passed to the Pager
+	DatumIn int64
+	// DatumSplit is the number of datum after stages ran and large Values arrays were split
+	DatumSplit int64
+	// Buckets is the number of requests datum were first bucketed into
+	Buckets int64
+	// Bisections is the number of times a request was too large and had to be split in half
+	Bisections int64
+	// BytesUncompressed is the size of request bodies before compression
+	BytesUncompressed int64
+	// BytesCompressed is the size of request bodies after compression
+	BytesCompressed int64
+	// Dropped is the number of datum dropped, by reason
+	Dropped map[DropReason]int64
+	// Retries is the number of times the AWS SDK retried a request
+	Retries int64
+	// SendLatency is how long each request to the Client took, including retries
+	SendLatency LatencyHistogram
+}
+
+// LatencyHistogram counts durations into buckets
+type LatencyHistogram struct {
+	// Bounds is the upper bound (inclusive) of each bucket.  Counts has one more item than Bounds, for durations
+	// larger than every bound.
+	Bounds []time.Duration
+	// Counts is the number of durations in each bucket.  Counts are not cumulative.
+	Counts []int64
+	// Count is the number of durations observed
+	Count int64
+	// Sum is the total of every duration observed
+	Sum time.Duration
+}
+
+// pagerStats holds the counters behind Stats.  Every field is accessed atomically.
+type pagerStats struct {
+	calls             int64
+	datumIn           int64
+	datumSplit        int64
+	buckets           int64
+	bisections        int64
+	bytesUncompressed int64
+	bytesCompressed   int64
+	retries           int64
+	dropped           [len(allDropReasons)]int64
+	latencyCounts     [len(latencyBounds) + 1]int64
+	latencyCount      int64
+	latencySum        int64
+}
+
+func (s *pagerStats) drop(reason DropReason, count int64) {
+	for i, r := range allDropReasons {
+		if r == reason {
+			atomic.AddInt64(&s.dropped[i], count)
+			return
+		}
+	}
+}
+
+func (s *pagerStats) observeLatency(d time.Duration) {
+	idx := len(latencyBounds)
+	for i, b := range latencyBounds {
+		if d <= b {
+			idx = i
+			break
+		}
+	}
+	atomic.AddInt64(&s.latencyCounts[idx], 1)
+	atomic.AddInt64(&s.latencyCount, 1)
+	atomic.AddInt64(&s.latencySum, int64(d))
+}
+
+func (s *pagerStats) onCompress(uncompressed int64, compressed int64) {
+	atomic.AddInt64(&s.bytesUncompressed, uncompressed)
+	atomic.AddInt64(&s.bytesCompressed, compressed)
+}
+
+func (s *pagerStats) snapshot() Stats {
+	ret := Stats{
+		Calls:             atomic.LoadInt64(&s.calls),
+		DatumIn:           atomic.LoadInt64(&s.datumIn),
+		DatumSplit:        atomic.LoadInt64(&s.datumSplit),
+		Buckets:           atomic.LoadInt64(&s.buckets),
+		Bisections:        atomic.LoadInt64(&s.bisections),
+		BytesUncompressed: atomic.LoadInt64(&s.bytesUncompressed),
+		BytesCompressed:   atomic.LoadInt64(&s.bytesCompressed),
+		Retries:           atomic.LoadInt64(&s.retries),
+		Dropped:           make(map[DropReason]int64, len(allDropReasons)),
+		SendLatency: LatencyHistogram{
+			Bounds: latencyBounds[:],
+			Counts: make([]int64, len(s.latencyCounts)),
+			Count:  atomic.LoadInt64(&s.latencyCount),
+			Sum:    time.Duration(atomic.LoadInt64(&s.latencySum)),
+		},
+	}
+	for i, r := range allDropReasons {
+		ret.Dropped[r] = atomic.LoadInt64(&s.dropped[i])
+	}
+	for i := range s.latencyCounts {
+		ret.SendLatency.Counts[i] = atomic.LoadInt64(&s.latencyCounts[i])
+	}
+	return ret
+}
+
+// stats returns the Pager's counters, creating them on first use
+func (c *Pager) stats() *pagerStats {
+	c.statsOnce.Do(func() {
+		c.pagerStats = &pagerStats{}
+	})
+	return c.pagerStats
+}
+
+// selfMetricsStats counts self metrics, so a SelfReporter never reports on its own sends.  It is never read.
+var selfMetricsStats = &pagerStats{}
+
+// statsFor returns the counters a send with ctx records to
+func (c *Pager) statsFor(ctx context.Context) *pagerStats {
+	if isSelfMetrics(ctx) {
+		return selfMetricsStats
+	}
+	return c.stats()
+}
+
+// Stats returns a snapshot of the Pager's internal counters.  It is safe to call concurrently with sends and cheap
+// enough to poll frequently.
+func (c *Pager) Stats() Stats {
+	return c.stats().snapshot()
+}
+
+// countStageDrops records datum a stage removed.  Stages may also add datum, so only a net decrease counts as drops.
+func (c *Pager) countStageDrops(reason DropReason, before int, after *cloudwatch.PutMetricDataInput) {
+	remaining := 0
+	if after != nil {
+		remaining = len(after.MetricData)
+	}
+	if remaining < before {
+		c.stats().drop(reason, int64(before-remaining))
+		c.Config.logger().Log(LevelDebug, "stage dropped datum", "reason", reason, "count", before-remaining)
+	}
+}
+
+// retriesHandlerName names the Complete handler added by retriesOption
+const retriesHandlerName = "cwpagedmetricput.retries"
+
+// retriesOption returns a request.Option that calls onRetries with how many times the request was retried
+func retriesOption(onRetries func(retries int)) request.Option {
+	return func(req *request.Request) {
+		h := request.NamedHandler{Name: retriesHandlerName, Fn: func(r *request.Request) {
+			if r.RetryCount > 0 {
+				onRetries(r.RetryCount)
+			}
+		}}
+		req.Handlers.Complete.Remove(h)
+		req.Handlers.Complete.PushBackNamed(h)
+	}
+}
+
+// onRetries records retries of a single request
+func (c *Pager) onRetries(retries int) {
+	atomic.AddInt64(&c.stats().retries, int64(retries))
+	c.Config.logger().Log(LevelInfo, "request retried", "retries", retries)
+}
@@ -0,0 +1,76 @@
+package cwpagedmetricput
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPager_Stats(t *testing.T) {
+	p := Pager{
+		Client: &memoryCloudWatchClient{},
+		Config: Config{
+			CardinalityGuard: &CardinalityGuard{MaxMetrics: maxDatumSize + 1},
+		},
+	}
+	_, err := p.PutMetricData(guardInput(0, maxDatumSize+5))
+	require.NoError(t, err)
+	stats := p.Stats()
+	require.Equal(t, int64(1), stats.Calls)
+	require.Equal(t, int64(maxDatumSize+5), stats.DatumIn)
+	require.Equal(t, int64(maxDatumSize+1), stats.DatumSplit)
+	require.Equal(t, int64(2), stats.Buckets)
+	require.Equal(t, int64(4), stats.Dropped[DropReasonCardinality])
+	require.Equal(t, int64(0), stats.Dropped[DropReasonSendError])
+	require.Equal(t, int64(2), stats.SendLatency.Count)
+	require.Len(t, stats.SendLatency.Counts, len(stats.SendLatency.Bounds)+1)
+}
+
+func TestPager_StatsBisections(t *testing.T) {
+	client := &memoryCloudWatchClient{errOnCall: 1, err: errors.New("bad")}
+	p := Pager{Client: client}
+	dat := make([]*cloudwatch.MetricDatum, 0, maxDatumSize)
+	for i := 0; i < maxDatumSize; i++ {
+		d := largeBaseDatum("TestPager_StatsBisections")
+		d.Value = aws.Float64(1)
+		dat = append(dat, d)
+	}
+	_, err := p.PutMetricData(&cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String("ns"),
+		MetricData: dat,
+	})
+	require.Error(t, err)
+	stats := p.Stats()
+	require.True(t, stats.Bisections > 0)
+	require.True(t, stats.Dropped[DropReasonSendError] > 0)
+}
+
+func Test_pagerStats_observeLatency(t *testing.T) {
+	var s pagerStats
+	s.observeLatency(time.Millisecond * 3)
+	s.observeLatency(time.Minute)
+	snap := s.snapshot()
+	require.Equal(t, int64(2), snap.SendLatency.Count)
+	require.Equal(t, time.Minute+time.Millisecond*3, snap.SendLatency.Sum)
+	require.Equal(t, int64(1), snap.SendLatency.Counts[1])
+	require.Equal(t, int64(1), snap.SendLatency.Counts[len(latencyBounds)])
+}
+
+func TestRetriesOption(t *testing.T) {
+	r := reqWithBody("hi")
+	r.RetryCount = 2
+	retries := 0
+	retriesOption(func(n int) {
+		retries = n
+	})(r)
+	retriesOption(func(n int) {
+		retries += n
+	})(r)
+	require.Equal(t, 1, r.Handlers.Complete.Len())
+	r.Handlers.Complete.Run(r)
+	require.Equal(t, 2, retries)
+}
@@ -0,0 +1,152 @@
+package statsd
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// series is the aggregated state of one metric name, type, and tag set
+type series struct {
+	name string
+	typ  Type
+	tags []Tag
+	// updated is true if the series was added to since the last flush
+	updated bool
+
+	// sum is the total of a counter, or the value of a gauge
+	sum float64
+	// samples maps each value of a timer, histogram, or distribution to its count
+	samples map[float64]float64
+	// members are the distinct members of a set
+	members map[string]struct{}
+}
+
+// aggregator combines metrics between flushes
+type aggregator struct {
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// seriesKey is the same for metrics that aggregate together
+func seriesKey(m *Metric) string {
+	parts := make([]string, 0, len(m.Tags))
+	for _, tag := range m.Tags {
+		parts = append(parts, tag.Key+":"+tag.Value)
+	}
+	sort.Strings(parts)
+	return string(m.Type) + "\x00" + m.Name + "\x00" + strings.Join(parts, "\x00")
+}
+
+// add merges m into its series.  It returns false, and drops m, if m would start a series past maxSeries.  Gauges kept
+// between flushes count toward maxSeries.
+func (a *aggregator) add(m Metric, maxSeries int) bool {
+	key := seriesKey(&m)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.series == nil {
+		a.series = make(map[string]*series)
+	}
+	s, exists := a.series[key]
+	if !exists {
+		if len(a.series) >= maxSeries {
+			return false
+		}
+		s = &series{name: m.Name, typ: m.Type, tags: m.Tags}
+		a.series[key] = s
+	}
+	s.updated = true
+	switch m.Type {
+	case Counter:
+		for _, v := range m.Values {
+			s.sum += v / m.SampleRate
+		}
+	case Gauge:
+		for _, v := range m.Values {
+			if m.Relative {
+				s.sum += v
+			} else {
+				s.sum = v
+			}
+		}
+	case Timer, Histogram, Distribution:
+		if s.samples == nil {
+			s.samples = make(map[float64]float64)
+		}
+		for _, v := range m.Values {
+			s.samples[v] += 1 / m.SampleRate
+		}
+	case Set:
+		if s.members == nil {
+			s.members = make(map[string]struct{})
+		}
+		s.members[m.Member] = struct{}{}
+	}
+	return true
+}
+
+// flush returns a datum for every series updated since the last flush and resets them.  Gauges keep their value, and
+// are sent every flush, unless deleteGauges is set.
+func (a *aggregator) flush(now time.Time, deleteGauges bool) []*cloudwatch.MetricDatum {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ret := make([]*cloudwatch.MetricDatum, 0, len(a.series))
+	for key, s := range a.series {
+		if s.typ == Gauge && !deleteGauges {
+			ret = append(ret, s.datum(now))
+			s.updated = false
+			continue
+		}
+		delete(a.series, key)
+		if s.updated {
+			ret = append(ret, s.datum(now))
+		}
+	}
+	return ret
+}
+
+// datum converts s to a MetricDatum.  Tags become dimensions, with tags that have no value given the value "true".
+func (s *series) datum(now time.Time) *cloudwatch.MetricDatum {
+	ret := &cloudwatch.MetricDatum{
+		MetricName: aws.String(s.name),
+		Timestamp:  aws.Time(now),
+	}
+	for _, tag := range s.tags {
+		value := tag.Value
+		if value == "" {
+			value = "true"
+		}
+		ret.Dimensions = append(ret.Dimensions, &cloudwatch.Dimension{
+			Name:  aws.String(tag.Key),
+			Value: aws.String(value),
+		})
+	}
+	switch s.typ {
+	case Counter:
+		ret.Unit = aws.String(cloudwatch.StandardUnitCount)
+		ret.Value = aws.Float64(s.sum)
+	case Gauge:
+		ret.Value = aws.Float64(s.sum)
+	case Set:
+		ret.Unit = aws.String(cloudwatch.StandardUnitCount)
+		ret.Value = aws.Float64(float64(len(s.members)))
+	default:
+		if s.typ == Timer {
+			ret.Unit = aws.String(cloudwatch.StandardUnitMilliseconds)
+		}
+		values := make([]float64, 0, len(s.samples))
+		for v := range s.samples {
+			values = append(values, v)
+		}
+		sort.Float64s(values)
+		for _, v := range values {
+			ret.Values = append(ret.Values, aws.Float64(v))
+			ret.Counts = append(ret.Counts, aws.Float64(s.samples[v]))
+		}
+	}
+	return ret
+}
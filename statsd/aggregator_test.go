@@ -0,0 +1,82 @@
+package statsd
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+// flushByName flushes a and indexes the datum by metric name
+func flushByName(a *aggregator, deleteGauges bool) map[string]*cloudwatch.MetricDatum {
+	ret := make(map[string]*cloudwatch.MetricDatum)
+	for _, d := range a.flush(time.Unix(1000, 0), deleteGauges) {
+		ret[*d.MetricName] = d
+	}
+	return ret
+}
+
+func mustParse(t *testing.T, lines ...string) []Metric {
+	ret := make([]Metric, 0, len(lines))
+	for _, line := range lines {
+		m, err := ParseLine([]byte(line))
+		require.NoError(t, err)
+		ret = append(ret, m)
+	}
+	return ret
+}
+
+func Test_aggregator(t *testing.T) {
+	var a aggregator
+	for _, m := range mustParse(t,
+		"requests:1|c",
+		"requests:1|c|@0.5",
+		"latency:20|ms",
+		"latency:10:20|ms|@0.5",
+		"size:3|h",
+		"queue:5|g",
+		"queue:+2|g",
+		"users:bob|s",
+		"users:alice|s",
+		"users:bob|s",
+		"tagged:1|c|#route:home,canary",
+	) {
+		a.add(m, defaultMaxSeries)
+	}
+	got := flushByName(&a, false)
+	require.Len(t, got, 6)
+
+	require.Equal(t, 3.0, *got["requests"].Value)
+	require.Equal(t, cloudwatch.StandardUnitCount, *got["requests"].Unit)
+
+	require.Equal(t, []*float64{aws.Float64(10), aws.Float64(20)}, got["latency"].Values)
+	require.Equal(t, []*float64{aws.Float64(2), aws.Float64(3)}, got["latency"].Counts)
+	require.Equal(t, cloudwatch.StandardUnitMilliseconds, *got["latency"].Unit)
+
+	require.Nil(t, got["size"].Unit)
+	require.Equal(t, 7.0, *got["queue"].Value)
+	require.Equal(t, 2.0, *got["users"].Value)
+	require.Equal(t, time.Unix(1000, 0), *got["users"].Timestamp)
+
+	dims := got["tagged"].Dimensions
+	sort.Slice(dims, func(i, j int) bool { return *dims[i].Name < *dims[j].Name })
+	require.Equal(t, "canary", *dims[0].Name)
+	require.Equal(t, "true", *dims[0].Value)
+	require.Equal(t, "route", *dims[1].Name)
+	require.Equal(t, "home", *dims[1].Value)
+
+	// Only the gauge is left, and it keeps its value
+	got = flushByName(&a, false)
+	require.Len(t, got, 1)
+	require.Equal(t, 7.0, *got["queue"].Value)
+	a.add(mustParse(t, "queue:-1|g")[0], defaultMaxSeries)
+	require.Equal(t, 6.0, *flushByName(&a, false)["queue"].Value)
+
+	// Deleted gauges are sent once more if they were updated, then forgotten
+	a.add(mustParse(t, "queue:1|g")[0], defaultMaxSeries)
+	require.Len(t, flushByName(&a, true), 1)
+	require.Len(t, flushByName(&a, true), 0)
+}
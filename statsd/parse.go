@@ -0,0 +1,149 @@
+package statsd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Type is the kind of a StatsD metric, as written after the first pipe of a line
+type Type string
+
+// The metric types of StatsD and DogStatsD
+const (
+	Counter      Type = "c"
+	Gauge        Type = "g"
+	Timer        Type = "ms"
+	Histogram    Type = "h"
+	Distribution Type = "d"
+	Set          Type = "s"
+)
+
+// Tag is a DogStatsD tag.  Tags without a colon have an empty Value.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Metric is a single parsed StatsD line
+type Metric struct {
+	Name string
+	Type Type
+	// Values are the numeric values of the line.  DogStatsD allows several, separated by colons.  Sets have none.
+	Values []float64
+	// Relative is true for gauges written with a sign, which change the gauge instead of setting it
+	Relative bool
+	// Member is the value of a set
+	Member string
+	// SampleRate is between 0 and 1.  It is 1 if the line has none.
+	SampleRate float64
+	Tags       []Tag
+}
+
+// maxTags is the most dimensions CloudWatch allows on a metric
+const maxTags = 30
+
+// errSkip is returned by ParseLine for DogStatsD events and service checks, which are not metrics
+var errSkip = errors.New("statsd: not a metric")
+
+// ParseLine parses a single StatsD or DogStatsD line, like "requests:1|c|@0.5|#route:home".  Unknown trailing sections,
+// like DogStatsD container IDs, are ignored.  A tag key written more than once keeps its last value, and lines with
+// more than 30 distinct tags or a tag without a key are rejected, since CloudWatch allows at most 30 dimensions and
+// needs a name for each.  Values that are NaN or infinite are rejected too.
+func ParseLine(line []byte) (Metric, error) {
+	if bytes.HasPrefix(line, []byte("_e{")) || bytes.HasPrefix(line, []byte("_sc|")) {
+		return Metric{}, errSkip
+	}
+	s := string(line)
+	colon := strings.IndexByte(s, ':')
+	if colon <= 0 {
+		return Metric{}, fmt.Errorf("statsd: line %q has no name", s)
+	}
+	ret := Metric{
+		Name:       s[:colon],
+		SampleRate: 1,
+	}
+	sections := strings.Split(s[colon+1:], "|")
+	if len(sections) < 2 {
+		return Metric{}, fmt.Errorf("statsd: line %q has no type", s)
+	}
+	ret.Type = Type(sections[1])
+	if err := ret.parseValue(sections[0]); err != nil {
+		return Metric{}, err
+	}
+	for _, section := range sections[2:] {
+		switch {
+		case strings.HasPrefix(section, "@"):
+			rate, err := strconv.ParseFloat(section[1:], 64)
+			if err != nil || !(rate > 0 && rate <= 1) {
+				return Metric{}, fmt.Errorf("statsd: invalid sample rate %q", section)
+			}
+			ret.SampleRate = rate
+		case strings.HasPrefix(section, "#"):
+			tags, err := parseTags(section[1:])
+			if err != nil {
+				return Metric{}, err
+			}
+			ret.Tags = tags
+			if len(ret.Tags) > maxTags {
+				return Metric{}, fmt.Errorf("statsd: line %q has %d tags, more than %d", s, len(ret.Tags), maxTags)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// parseValue sets the values of m from the part of a line between the name and type
+func (m *Metric) parseValue(value string) error {
+	switch m.Type {
+	case Set:
+		m.Member = value
+		return nil
+	case Counter, Gauge, Timer, Histogram, Distribution:
+	default:
+		return fmt.Errorf("statsd: unknown type %q", m.Type)
+	}
+	parts := strings.Split(value, ":")
+	m.Values = make([]float64, 0, len(parts))
+	for _, part := range parts {
+		if m.Type == Gauge && (strings.HasPrefix(part, "+") || strings.HasPrefix(part, "-")) {
+			m.Relative = true
+		}
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("statsd: invalid value %q", part)
+		}
+		m.Values = append(m.Values, v)
+	}
+	return nil
+}
+
+// parseTags parses comma separated tags.  Each key is only returned once, with its last value, since dimension names
+// must be unique.  A tag with an empty key is an error.
+func parseTags(tags string) ([]Tag, error) {
+	parts := strings.Split(tags, ",")
+	ret := make([]Tag, 0, len(parts))
+	index := make(map[string]int, len(parts))
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		tag := Tag{Key: part}
+		if colon := strings.IndexByte(part, ':'); colon != -1 {
+			tag = Tag{Key: part[:colon], Value: part[colon+1:]}
+		}
+		if tag.Key == "" {
+			return nil, fmt.Errorf("statsd: tag %q has no key", part)
+		}
+		if i, exists := index[tag.Key]; exists {
+			ret[i] = tag
+			continue
+		}
+		index[tag.Key] = len(ret)
+		ret = append(ret, tag)
+	}
+	return ret, nil
+}
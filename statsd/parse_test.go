@@ -0,0 +1,82 @@
+package statsd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	manyTags := make([]string, maxTags+1)
+	for i := range manyTags {
+		manyTags[i] = fmt.Sprintf("t%d:v", i)
+	}
+	tests := []struct {
+		line    string
+		want    Metric
+		wantErr bool
+	}{
+		{
+			line: "requests:1|c",
+			want: Metric{Name: "requests", Type: Counter, Values: []float64{1}, SampleRate: 1},
+		},
+		{
+			line: "requests:2|c|@0.5|#route:home,canary",
+			want: Metric{
+				Name:       "requests",
+				Type:       Counter,
+				Values:     []float64{2},
+				SampleRate: 0.5,
+				Tags:       []Tag{{Key: "route", Value: "home"}, {Key: "canary"}},
+			},
+		},
+		{
+			line: "latency:1.5:2:3|ms|c:abc123",
+			want: Metric{Name: "latency", Type: Timer, Values: []float64{1.5, 2, 3}, SampleRate: 1},
+		},
+		{
+			line: "queue:-3|g",
+			want: Metric{Name: "queue", Type: Gauge, Values: []float64{-3}, Relative: true, SampleRate: 1},
+		},
+		{
+			line: "users:bob|s",
+			want: Metric{Name: "users", Type: Set, Member: "bob", SampleRate: 1},
+		},
+		{
+			line: "requests:1|c|#env:dev,route:home,env:prod",
+			want: Metric{
+				Name:       "requests",
+				Type:       Counter,
+				Values:     []float64{1},
+				SampleRate: 1,
+				Tags:       []Tag{{Key: "env", Value: "prod"}, {Key: "route", Value: "home"}},
+			},
+		},
+		{line: "requests:1|c|#" + strings.Join(manyTags, ","), wantErr: true},
+		{line: "requests|c", wantErr: true},
+		{line: "requests:1", wantErr: true},
+		{line: "requests:one|c", wantErr: true},
+		{line: "requests:1|x", wantErr: true},
+		{line: "requests:1|c|@2", wantErr: true},
+		{line: "requests:1|c|@NaN", wantErr: true},
+		{line: "requests:NaN|c", wantErr: true},
+		{line: "latency:1:+Inf|ms", wantErr: true},
+		{line: "temperature:-Inf|g", wantErr: true},
+		{line: "requests:1|c|#:home", wantErr: true},
+		{line: "requests:1|c|#route:home,:v", wantErr: true},
+		{line: "_e{5,4}:title|text", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := ParseLine([]byte(tt.line))
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
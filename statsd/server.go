@@ -0,0 +1,142 @@
+/*
+Package statsd is a StatsD and DogStatsD server that aggregates metrics and publishes them to CloudWatch through a
+cwpagedmetricput.Pager.
+*/
+package statsd
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/cep21/cwpagedmetricput"
+	"github.com/cep21/cwpagedmetricput/internal/defaults"
+	"github.com/cep21/cwpagedmetricput/internal/flushloop"
+)
+
+const (
+	defaultFlushInterval = 10 * time.Second
+	defaultMaxSeries     = 100000
+	// defaultMaxPacketSize is the largest UDP payload
+	defaultMaxPacketSize = 65535
+)
+
+// Server aggregates StatsD packets and publishes them every FlushInterval.  Counters and sets are sent as a single
+// value, gauges as their last value, and timers, histograms, and distributions as Values and Counts so CloudWatch can
+// compute percentiles.  DogStatsD tags become dimensions.
+type Server struct {
+	// Client receives the metrics.  It is required and is usually a *cwpagedmetricput.Pager.
+	Client cwpagedmetricput.CloudWatchClient
+	// Namespace of every metric.  It is required.
+	Namespace string
+	// FlushInterval is how often metrics are published.  Defaults to 10 seconds.
+	FlushInterval time.Duration
+	// DeleteGauges stops sending gauges that were not updated since the last flush.  By default, like StatsD, gauges
+	// send their last value every flush.
+	DeleteGauges bool
+	// MaxPacketSize is the largest packet read.  Defaults to 65535.
+	MaxPacketSize int
+	// MaxSeries limits how many distinct series, each a name, type, and tag set, are held between flushes.  Gauges
+	// kept between flushes count toward it.  Metrics that would start a series past it are dropped.  Defaults to
+	// 100000.
+	MaxSeries int
+	// OnDropped, if set, is called with each metric dropped because of MaxSeries
+	OnDropped func(m Metric)
+	// Logger receives invalid lines and publish errors.  Defaults to cwpagedmetricput.NopLogger.
+	Logger cwpagedmetricput.Logger
+
+	agg aggregator
+	// now is time.Now, but can be replaced for testing
+	now func() time.Time
+}
+
+func (s *Server) flushInterval() time.Duration {
+	if s.FlushInterval <= 0 {
+		return defaultFlushInterval
+	}
+	return s.FlushInterval
+}
+
+func (s *Server) maxPacketSize() int {
+	if s.MaxPacketSize <= 0 {
+		return defaultMaxPacketSize
+	}
+	return s.MaxPacketSize
+}
+
+func (s *Server) maxSeries() int {
+	if s.MaxSeries <= 0 {
+		return defaultMaxSeries
+	}
+	return s.MaxSeries
+}
+
+// ListenAndServe listens for UDP packets on addr, like ":8125", and calls Serve
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, conn)
+}
+
+// Serve reads packets from conn and publishes metrics until ctx ends or reading fails.  It closes conn, then publishes
+// what is left, returning the error of that last flush.
+func (s *Server) Serve(ctx context.Context, conn net.PacketConn) error {
+	return flushloop.Run(ctx, s.flushInterval(), conn, func() error {
+		return s.readPackets(conn)
+	}, s.Flush, func(err error) {
+		defaults.Logger(s.Logger).Log(cwpagedmetricput.LevelWarn, "unable to publish statsd metrics", "err", err)
+	})
+}
+
+func (s *Server) readPackets(conn net.PacketConn) error {
+	buf := make([]byte, s.maxPacketSize())
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if n > 0 {
+			s.HandlePacket(buf[:n])
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// HandlePacket aggregates every newline separated line of packet.  Use it to feed metrics from a transport other than
+// UDP.
+func (s *Server) HandlePacket(packet []byte) {
+	for _, line := range bytes.Split(packet, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		m, err := ParseLine(line)
+		if err == errSkip {
+			continue
+		}
+		if err != nil {
+			defaults.Logger(s.Logger).Log(cwpagedmetricput.LevelWarn, "invalid statsd line", "line", string(line), "err", err)
+			continue
+		}
+		if !s.agg.add(m, s.maxSeries()) && s.OnDropped != nil {
+			s.OnDropped(m)
+		}
+	}
+}
+
+// Flush publishes everything aggregated since the last flush
+func (s *Server) Flush(ctx context.Context) error {
+	datum := s.agg.flush(defaults.Now(s.now), s.DeleteGauges)
+	if len(datum) == 0 {
+		return nil
+	}
+	_, err := s.Client.PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String(s.Namespace),
+		MetricData: datum,
+	})
+	return err
+}
@@ -0,0 +1,86 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cep21/cwpagedmetricput"
+	"github.com/cep21/cwpagedmetricput/internal/cwtest"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (r *recordingLogger) Log(_ cwpagedmetricput.LogLevel, msg string, _ ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, msg)
+}
+
+func TestServer_HandlePacket(t *testing.T) {
+	client := &cwtest.Client{}
+	logger := &recordingLogger{}
+	s := &Server{Client: client, Namespace: "ns", Logger: logger}
+	s.HandlePacket([]byte("a:1|c\na:2|c\n\n_sc|check|0\nbad line\n"))
+	require.Equal(t, []string{"invalid statsd line"}, logger.msgs)
+	require.NoError(t, s.Flush(context.Background()))
+	require.Len(t, client.Inputs(), 1)
+	require.Equal(t, "ns", *client.Inputs()[0].Namespace)
+	require.Equal(t, 3.0, *client.Inputs()[0].MetricData[0].Value)
+
+	// Nothing aggregated, so nothing sent
+	require.NoError(t, s.Flush(context.Background()))
+	require.Len(t, client.Inputs(), 1)
+}
+
+func TestServer_MaxSeries(t *testing.T) {
+	client := &cwtest.Client{}
+	var dropped []string
+	s := &Server{Client: client, Namespace: "ns", MaxSeries: 2, OnDropped: func(m Metric) {
+		dropped = append(dropped, m.Name)
+	}}
+	s.HandlePacket([]byte("queue:1|g\na:1|c\na:2|c\nb:1|c"))
+	require.Equal(t, []string{"b"}, dropped)
+	require.NoError(t, s.Flush(context.Background()))
+	require.Len(t, client.Datum(), 2)
+
+	// The kept gauge still counts toward MaxSeries
+	s.HandlePacket([]byte("b:1|c\nc:1|c"))
+	require.Equal(t, []string{"b", "c"}, dropped)
+}
+
+func TestServer_Serve(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	client := &cwtest.Client{}
+	s := &Server{Client: client, Namespace: "ns", FlushInterval: time.Millisecond * 10}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(ctx, conn)
+	}()
+
+	sender, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, sender.Close())
+	}()
+	_, err = sender.Write([]byte("latency:5|ms|#host:a"))
+	require.NoError(t, err)
+	for i := 0; i < 100 && len(client.Datum()) == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	require.NotEmpty(t, client.Datum())
+
+	cancel()
+	require.NoError(t, <-done)
+	d := client.Datum()[0]
+	require.Equal(t, "latency", *d.MetricName)
+	require.Equal(t, "host", *d.Dimensions[0].Name)
+}
@@ -0,0 +1,84 @@
+package cwpagedmetricput
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Tracer starts spans around the work Pager does.  It has no dependencies, so an adapter for a tracing library such as
+// OpenTelemetry is only a few lines.
+type Tracer interface {
+	// StartSpan starts a span named name, as a child of any span already in ctx, and returns a context holding the new
+	// span
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single traced operation started by a Tracer
+type Span interface {
+	// SetAttribute records a key/value pair on the span.  Values are strings, ints, or int64s.
+	SetAttribute(key string, value interface{})
+	// RecordError records that the operation failed
+	RecordError(err error)
+	// End finishes the span
+	End()
+}
+
+// Span names used by Pager
+const (
+	// SpanPutMetricData wraps an entire PutMetricDataWithContext call
+	SpanPutMetricData = "cwpagedmetricput.PutMetricData"
+	// SpanSendBucket wraps sending one bucket of datum
+	SpanSendBucket = "cwpagedmetricput.sendBucket"
+	// SpanBisect wraps sending one half of a bucket that was too large to send at once
+	SpanBisect = "cwpagedmetricput.bisect"
+)
+
+// Span attribute keys used by Pager
+const (
+	AttributeNamespace      = "cwpagedmetricput.namespace"
+	AttributeDatumCount     = "cwpagedmetricput.datum_count"
+	AttributeBucketCount    = "cwpagedmetricput.bucket_count"
+	AttributeCompressedSize = "cwpagedmetricput.compressed_size"
+	AttributeAWSRequestID   = "aws.request_id"
+)
+
+// noopSpan is the Span used when there is no Tracer
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) RecordError(error)                {}
+func (noopSpan) End()                             {}
+
+// startSpan starts a span with the config's Tracer, if there is one
+func (c *Pager) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if c.Config.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return c.Config.Tracer.StartSpan(ctx, name)
+}
+
+// traceOption returns a request.Option that records the compressed body size and AWS request ID on span.  Add it
+// after GzipBody so it sees the compressed body.
+func traceOption(span Span) request.Option {
+	return func(req *request.Request) {
+		sizeHandler := request.NamedHandler{Name: "cwpagedmetricput.trace.size", Fn: func(r *request.Request) {
+			if r.Error != nil {
+				return
+			}
+			if size, err := aws.SeekerLen(r.GetBody()); err == nil {
+				span.SetAttribute(AttributeCompressedSize, size)
+			}
+		}}
+		requestIDHandler := request.NamedHandler{Name: "cwpagedmetricput.trace.requestid", Fn: func(r *request.Request) {
+			if r.RequestID != "" {
+				span.SetAttribute(AttributeAWSRequestID, r.RequestID)
+			}
+		}}
+		req.Handlers.Build.Remove(sizeHandler)
+		req.Handlers.Build.PushBackNamed(sizeHandler)
+		req.Handlers.Complete.Remove(requestIDHandler)
+		req.Handlers.Complete.PushBackNamed(requestIDHandler)
+	}
+}
@@ -0,0 +1,116 @@
+package cwpagedmetricput
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/require"
+)
+
+type testSpan struct {
+	name   string
+	parent *testSpan
+	attrs  map[string]interface{}
+	err    error
+	ended  bool
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) {
+	s.attrs[key] = value
+}
+
+func (s *testSpan) RecordError(err error) {
+	s.err = err
+}
+
+func (s *testSpan) End() {
+	s.ended = true
+}
+
+type testSpanKey struct{}
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parent, _ := ctx.Value(testSpanKey{}).(*testSpan)
+	s := &testSpan{name: name, parent: parent, attrs: make(map[string]interface{})}
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, testSpanKey{}, s), s
+}
+
+func (t *testTracer) named(name string) []*testSpan {
+	var ret []*testSpan
+	for _, s := range t.spans {
+		if s.name == name {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+func TestPager_Tracer(t *testing.T) {
+	tracer := &testTracer{}
+	p := Pager{
+		Client: &memoryCloudWatchClient{},
+		Config: Config{Tracer: tracer},
+	}
+	_, err := p.PutMetricData(guardInput(0, maxDatumSize+1))
+	require.NoError(t, err)
+	top := tracer.named(SpanPutMetricData)
+	require.Len(t, top, 1)
+	require.Nil(t, top[0].parent)
+	require.True(t, top[0].ended)
+	require.Equal(t, 2, top[0].attrs[AttributeBucketCount])
+	require.Equal(t, "ns", top[0].attrs[AttributeNamespace])
+	buckets := tracer.named(SpanSendBucket)
+	require.Len(t, buckets, 2)
+	for _, b := range buckets {
+		require.Equal(t, top[0], b.parent)
+		require.True(t, b.ended)
+	}
+}
+
+func TestPager_TracerBisect(t *testing.T) {
+	tracer := &testTracer{}
+	p := Pager{
+		Client: &memoryCloudWatchClient{errOnCall: 1, err: errors.New("bad")},
+		Config: Config{Tracer: tracer, SerialSends: true},
+	}
+	dat := make([]*cloudwatch.MetricDatum, 0, maxDatumSize)
+	for i := 0; i < maxDatumSize; i++ {
+		d := largeBaseDatum("TestPager_TracerBisect")
+		d.Value = aws.Float64(1)
+		dat = append(dat, d)
+	}
+	_, err := p.PutMetricData(&cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String("ns"),
+		MetricData: dat,
+	})
+	require.Error(t, err)
+	require.Equal(t, err, tracer.named(SpanPutMetricData)[0].err)
+	bisects := tracer.named(SpanBisect)
+	require.NotEmpty(t, bisects)
+	require.Equal(t, SpanSendBucket, bisects[0].parent.name)
+	require.Equal(t, maxDatumSize/2, bisects[0].attrs[AttributeDatumCount])
+}
+
+func TestTraceOption(t *testing.T) {
+	r := reqWithBody("hello world")
+	r.RequestID = "abc"
+	span := &testSpan{attrs: make(map[string]interface{})}
+	GzipBody(r)
+	traceOption(span)(r)
+	r.Handlers.Build.Run(r)
+	r.Handlers.Complete.Run(r)
+	require.Equal(t, "abc", span.attrs[AttributeAWSRequestID])
+	require.NotNil(t, span.attrs[AttributeCompressedSize])
+}